@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+)
+
+// archiveSuffix is appended to the default name of a segment to name its archive.
+const archiveSuffix = ".gz"
+
+// archivePath returns the path of the archive of the given segment in the archive directory.
+func (w *wrapper) archivePath(segmentNum int) string {
+	return filepath.Join(w.archiveDir, defaultSegmentName(segmentNum)+archiveSuffix)
+}
+
+// evictSegment removes a segment evicted by MaxSize, MaxSegments or DeleteOlderThan, archiving it first if
+// Config.ArchiveDir is set. A segment failing to be archived isn't removed. Must be called with mtx held.
+func (w *wrapper) evictSegment(segment segmentRef) error {
+	if w.archiveDir != "" {
+		if err := w.archiveSegment(segment); err != nil {
+			return fmt.Errorf("error archiving segment %d: %w", segment.number, err)
+		}
+	}
+	return w.removeSegment(segment.name, segment.number)
+}
+
+// archiveSegment gzips the segment into the archive directory, replacing any previous archive of a segment with the
+// same number. The archive is written atomically, and synced before the segment can be removed. Must be called with mtx
+// held.
+func (w *wrapper) archiveSegment(segment segmentRef) error {
+	src, err := os.Open(filepath.Join(w.wal.Dir(), segment.name))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(w.archiveDir, ".archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := fileutil.Rename(dst.Name(), w.archivePath(segment.number)); err != nil {
+		return err
+	}
+	level.Info(w.log).Log("msg", "archived WAL segment", "segment", segment.number, "dir", w.archiveDir)
+	return nil
+}
+
+// RestoreArchive decompresses the archive of the given segment, written when Config.ArchiveDir is set, back into the
+// WAL directory, so that its records are replayed again. The archive is kept. Only segments older than the one being
+// written to can be restored, and an existing segment is only replaced if empty, as the ones filling gaps left by
+// removed segments are. Restored segments aren't mirrored.
+func (w *wrapper) RestoreArchive(segmentNum int) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	if w.archiveDir == "" {
+		return errors.New("WAL archive dir is not configured")
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	if err := w.restoreArchive(segmentNum); err != nil {
+		return &SegmentError{SegmentNum: segmentNum, Op: "restore", Err: err}
+	}
+	return w.dirChanged()
+}
+
+// restoreArchive decompresses the archive of the given segment into the WAL directory. Must be called with mtx held.
+func (w *wrapper) restoreArchive(segmentNum int) error {
+	head, err := w.currentSegment()
+	if err != nil {
+		return err
+	}
+	if segmentNum >= head {
+		return fmt.Errorf("must be older than the segment being written to, %d", head)
+	}
+	path := w.segmentPath(segmentNum)
+	if fi, err := w.fs.Stat(path); err == nil && fi.Size() > 0 {
+		return errors.New("segment already exists")
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	src, err := os.Open(w.archivePath(segmentNum))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("error reading archive: %w", err)
+	}
+	dst, err := os.CreateTemp(w.wal.Dir(), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	_, err = io.Copy(dst, gz)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error restoring archive: %w", err)
+	}
+	return fileutil.Rename(dst.Name(), path)
+}
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_ArchiveEvictedSegments(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, ArchiveDir: archiveDir, MaxSegments: 2}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{2, 3}, segmentNumbers(t, dir))
+	for _, segment := range []int{0, 1} {
+		f, err := os.Open(filepath.Join(archiveDir, fmt.Sprintf("%08d.gz", segment)))
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		data, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		require.NotEmpty(t, data)
+		require.NoError(t, f.Close())
+	}
+
+	ww := w.(Extended)
+	require.NoError(t, ww.RestoreArchive(0))
+	require.Equal(t, []int{0, 2, 3}, segmentNumbers(t, dir))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 0", "line 2"}, lines)
+
+	var segmentErr *SegmentError
+	require.ErrorAs(t, ww.RestoreArchive(0), &segmentErr)
+	require.ErrorContains(t, ww.RestoreArchive(0), "restore segment 0: segment already exists")
+	require.ErrorContains(t, ww.RestoreArchive(3), "must be older than the segment being written to")
+	require.NoError(t, os.Remove(filepath.Join(archiveDir, "00000001.gz")))
+	require.ErrorIs(t, ww.RestoreArchive(1), os.ErrNotExist)
+}
+
+func TestWAL_ArchiveOlderSegments(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, ArchiveDir: archiveDir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "old"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "00000000"), old, old))
+	deleted, err := w.DeleteOlderThan(time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Equal(t, []int{1}, segmentNumbers(t, dir))
+
+	require.NoError(t, w.(Extended).RestoreArchive(0))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"old"}, lines)
+}
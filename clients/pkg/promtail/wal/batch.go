@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// recordHeaderSize mirrors the size of the header wlog writes before each record fragment.
+const recordHeaderSize = 7
+
+// LogBatch writes all records to the WAL in a single operation that is never split across segments. If the batch
+// doesn't fit in the space left in the current segment, the WAL rotates to a new segment before writing it.
+//
+// The guarantee is limited to segment rotation: the WAL still can't write atomically to disk, so a crash while writing
+// can leave only a prefix of the batch persisted, and Repair trims just the torn record, keeping the records before it.
+// A batch that doesn't fit in an empty segment fails with ErrRecordTooLarge, without writing anything.
+func (w *wrapper) LogBatch(records []*wal.Record) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	defer w.notifySegmentsCreated()
+	if w.cfg.LogHook != nil {
+		hooked := make([]*wal.Record, 0, len(records))
+		for _, record := range records {
+			if record, ok := w.applyLogHook(record); ok {
+				hooked = append(hooked, record)
+			}
+		}
+		records = hooked
+	}
+	if err := w.logBatchRecords(records); err != nil {
+		w.logFailed(err)
+		return err
+	}
+	return nil
+}
+
+func (w *wrapper) logBatchRecords(records []*wal.Record) error {
+	var bufs []*[]byte
+	defer func() {
+		for _, buf := range bufs {
+			w.pool.PutBytes(buf)
+		}
+	}()
+	var recs [][]byte
+	encode := func(encoder func([]byte) []byte) {
+		buf := w.pool.GetBytes()
+		*buf = encodeRecord(*buf, w.cfg.RecordChecksums, encoder)
+		bufs = append(bufs, buf)
+		recs = append(recs, *buf)
+	}
+	// Always write series then entries, for every record.
+	for _, record := range records {
+		if isEmptyRecord(record) {
+			if err := w.emptyRecordErr(); err != nil {
+				return err
+			}
+			continue
+		}
+		if w.cfg.Encoder != nil {
+			encoded, err := w.encodeCustom(record)
+			if err != nil {
+				return err
+			}
+			recs = append(recs, encoded...)
+			continue
+		}
+		if len(record.Series) > 0 {
+			encode(encodeSeries(record, w.cfg.DeterministicEncoding))
+		}
+		if len(record.RefEntries) > 0 {
+			encode(encodeEntries(record, w.cfg.entriesRecordVersion()))
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+
+	if err := w.logFreeingSpace(func() error { return w.logBatch(recs) }); err != nil {
+		return err
+	}
+	w.wroteRecords()
+	return nil
+}
+
+// logBatch writes recs in a single wlog operation, rotating first if they don't fit in the current segment.
+func (w *wrapper) logBatch(recs [][]byte) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	var needed int
+	for _, rec := range recs {
+		if err := w.checkRecordSize(rec); err != nil {
+			return err
+		}
+		needed += batchRecordSize(len(rec))
+	}
+	segmentSize := w.cfg.segmentSize()
+	if needed > segmentSize {
+		return fmt.Errorf("%w: encoded batch of about %d bytes exceeds the segment size of %d bytes", ErrRecordTooLarge, needed, segmentSize)
+	}
+	// the batch is written unbuffered, after any buffered record, so that it's never split across segments
+	if err := w.flushBuffer(); err != nil {
+		return err
+	}
+	used, err := w.headSegmentSize()
+	if err != nil {
+		return err
+	}
+	if used+int64(needed) > int64(segmentSize) {
+		segment, err := w.nextSegment()
+		if err != nil {
+			return fmt.Errorf("failed to rotate WAL before logging batch: %w", err)
+		}
+		level.Debug(w.log).Log("msg", "rotated WAL to a new segment before logging batch", "segment", segment, "bytes", needed)
+	}
+	if err := w.logToWAL(recs...); err != nil {
+		return err
+	}
+	var written int
+	for _, rec := range recs {
+		w.recordLogged(len(rec))
+		written += len(rec)
+	}
+	level.Debug(w.log).Log("msg", "logged batch to WAL", "records", len(recs), "bytes", written)
+	w.preallocateHead()
+	if w.cfg.SyncMode == SyncModePerRecord {
+		if err := w.syncWAL(); err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+	return nil
+}
+
+// headSegmentSize returns the bytes written so far to the segment currently being written. Since wlog flushes the last
+// record of each write, this accounts for everything logged. Must be called with mtx held.
+func (w *wrapper) headSegmentSize() (int64, error) {
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return 0, err
+	}
+	if len(segments) == 0 {
+		return 0, nil
+	}
+	fi, err := w.fs.Stat(filepath.Join(w.wal.Dir(), segments[len(segments)-1].name))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// batchRecordSize conservatively estimates the space a record of the given size takes in a segment, accounting for a
+// header per page it might be fragmented into. Compression only applies if it shrinks the record, so it's ignored.
+func batchRecordSize(size int) int {
+	fragments := size/(walPageSize-recordHeaderSize) + 2
+	return size + fragments*recordHeaderSize
+}
@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// segmentLines returns all entry lines in the given segment of w.
+func segmentLines(t *testing.T, w WAL, segment int) []string {
+	var lines []string
+	corruption, err := w.(*wrapper).replaySegment(segment, &wal.Record{}, func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, corruption)
+	require.NoError(t, err)
+	return lines
+}
+
+func TestWAL_LogBatch(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	padding := strings.Repeat("x", 10*1024)
+	newBatch := func(prefix string, size int) ([]*wal.Record, []string) {
+		var records []*wal.Record
+		var lines []string
+		for i := 0; i < size; i++ {
+			line := fmt.Sprintf("%s %d %s", prefix, i, padding)
+			records = append(records, newTestRecord(uint64(i), line))
+			lines = append(lines, line)
+		}
+		return records, lines
+	}
+
+	// fill most of the first segment, leaving room for less than 20 records
+	filler, fillerLines := newBatch("filler", 90)
+	for _, rec := range filler {
+		requireLog(t, w, rec)
+	}
+
+	small, smallLines := newBatch("small", 2)
+	require.NoError(t, w.LogBatch(small))
+	current, err := w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, 0, current, "a batch that fits should not rotate the WAL")
+
+	batch, batchLines := newBatch("batch", 20)
+	require.NoError(t, w.LogBatch(batch))
+	current, err = w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, 1, current)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, append(fillerLines, smallLines...), segmentLines(t, w, 0))
+	require.Equal(t, batchLines, segmentLines(t, w, 1))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, append(append(fillerLines, smallLines...), batchLines...), lines)
+}
+
+func TestWAL_LogBatchTooLarge(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var batch []*wal.Record
+	for i := 0; i < 4; i++ {
+		batch = append(batch, newTestRecord(uint64(i), strings.Repeat("x", minSegmentSize/4)))
+	}
+	require.ErrorIs(t, w.LogBatch(batch), ErrRecordTooLarge)
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(versionRecordSize), size)
+}
+
+// listingWL lists the segments in dir on every write.
+type listingWL struct {
+	writeLog
+	t      *testing.T
+	dir    string
+	listed [][]int
+}
+
+func (l *listingWL) Log(recs ...[]byte) error {
+	l.listed = append(l.listed, segmentNumbers(l.t, l.dir))
+	return l.writeLog.Log(recs...)
+}
+
+func TestWAL_LogBatchRotationEvicts(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize, MaxSegments: 2}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	listing := injectWL(w, func(wl writeLog) *listingWL { return &listingWL{writeLog: wl, t: t, dir: dir} })
+
+	// each batch takes more than half a segment, so that every batch after the first rotates the WAL, evicting the
+	// oldest segment before the batch is written
+	for b := 0; b < 4; b++ {
+		var batch []*wal.Record
+		for i := 0; i < 5; i++ {
+			batch = append(batch, newTestRecord(uint64(i), strings.Repeat("x", minSegmentSize/8)))
+		}
+		require.NoError(t, w.LogBatch(batch))
+	}
+	require.Equal(t, [][]int{{0}, {0, 1}, {1, 2}, {2, 3}}, listing.listed)
+}
@@ -0,0 +1,87 @@
+package wal
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-kit/log/level"
+)
+
+// recordBuffer holds encoded records logged while Config.BufferSize is set, until they're flushed to the underlying
+// WAL. Records are kept in the order they were logged.
+type recordBuffer struct {
+	mtx  sync.Mutex
+	recs [][]byte
+	size int
+}
+
+// logRecords writes recs to the underlying WAL, or appends them to the buffer if Config.BufferSize is set, flushing
+// it once full. Must be called with mtx held, either for reading or writing.
+func (w *wrapper) logRecords(recs ...[]byte) error {
+	if w.cfg.BufferSize <= 0 {
+		return w.logToWAL(recs...)
+	}
+	w.buffer.mtx.Lock()
+	defer w.buffer.mtx.Unlock()
+	for _, rec := range recs {
+		// callers reuse their buffers once logged, so records must be copied
+		w.buffer.recs = append(w.buffer.recs, append([]byte(nil), rec...))
+		w.buffer.size += len(rec)
+	}
+	if w.buffer.size < w.cfg.BufferSize {
+		return nil
+	}
+	return w.pendingFlush(w.flushBufferLocked())
+}
+
+// Flush writes all buffered records to the underlying WAL, when Config.BufferSize is set. Like records written
+// without a buffer, flushed records aren't synced to disk until Sync is called.
+func (w *wrapper) Flush() error {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	return w.flushBuffer()
+}
+
+// flushBuffer is Flush, but must be called with mtx held, either for reading or writing.
+func (w *wrapper) flushBuffer() error {
+	if w.cfg.BufferSize <= 0 {
+		return nil
+	}
+	w.buffer.mtx.Lock()
+	defer w.buffer.mtx.Unlock()
+	return w.flushBufferLocked()
+}
+
+// flushBufferLocked writes the buffered records in a single WAL operation. Records are dropped from the buffer even if
+// the write fails, since they may have been partially written, unless the error is retryable and Config.WriteRetries
+// is set, in which case they're kept for the next flush. Must be called with buffer.mtx held.
+func (w *wrapper) flushBufferLocked() error {
+	if len(w.buffer.recs) == 0 {
+		return nil
+	}
+	recs, size := w.buffer.recs, w.buffer.size
+	w.buffer.recs, w.buffer.size = nil, 0
+	if err := w.logToWAL(recs...); err != nil {
+		if w.cfg.WriteRetries > 0 && retryableWriteError(err) {
+			w.buffer.recs, w.buffer.size = recs, size
+		}
+		return fmt.Errorf("failed to flush %d buffered records to WAL: %w", len(recs), err)
+	}
+	return nil
+}
+
+// syncWAL flushes buffered records, if any, and syncs the underlying WAL. Must be called with mtx held, either for
+// reading or writing.
+func (w *wrapper) syncWAL() error {
+	if err := w.flushBuffer(); err != nil {
+		return err
+	}
+	if err := w.wal.Sync(); err != nil {
+		return err
+	}
+	level.Debug(w.log).Log("msg", "synced WAL")
+	return nil
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_Buffer(t *testing.T) {
+	newBufferedWAL := func(t *testing.T, dir string, size int) (WAL, *flakyWL) {
+		w, err := New(Config{Enabled: true, Dir: dir, BufferSize: size}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		// a flakyWL never failing counts the writes reaching the underlying wal
+		wl := injectWL(w, func(wl writeLog) *flakyWL { return &flakyWL{writeLog: wl} })
+		return w, wl
+	}
+
+	t.Run("flushed on sync", func(t *testing.T) {
+		dir := t.TempDir()
+		w, wl := newBufferedWAL(t, dir, 1<<20)
+		requireLog(t, w, newTestRecord(1, "first"))
+		requireLog(t, w, newTestRecord(1, "second"))
+		require.Zero(t, wl.attempts)
+
+		require.NoError(t, w.Sync())
+		require.Equal(t, 1, wl.attempts)
+		require.NoError(t, w.Close())
+		require.Equal(t, 1, wl.attempts)
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"first", "second"}, lines)
+	})
+
+	t.Run("flushed on close", func(t *testing.T) {
+		dir := t.TempDir()
+		w, wl := newBufferedWAL(t, dir, 1<<20)
+		requireLog(t, w, newTestRecord(1, "first"))
+		require.NoError(t, w.Close())
+		require.Equal(t, 1, wl.attempts)
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"first"}, lines)
+	})
+
+	t.Run("flush mid buffer", func(t *testing.T) {
+		dir := t.TempDir()
+		w, wl := newBufferedWAL(t, dir, 1<<20)
+		requireLog(t, w, newTestRecord(1, "first"))
+		require.NoError(t, w.(Extended).Flush())
+		require.Equal(t, 1, wl.attempts)
+		// flushing an empty buffer writes nothing
+		require.NoError(t, w.(Extended).Flush())
+		require.Equal(t, 1, wl.attempts)
+
+		requireLog(t, w, newTestRecord(1, "second"))
+		require.Equal(t, 1, wl.attempts)
+		require.NoError(t, w.Close())
+		require.Equal(t, 2, wl.attempts)
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"first", "second"}, lines)
+	})
+
+	t.Run("flushed when full", func(t *testing.T) {
+		dir := t.TempDir()
+		recs, err := EncodeRecord(newTestRecord(1, "line"))
+		require.NoError(t, err)
+		var size int
+		for _, rec := range recs {
+			size += len(rec)
+		}
+		// the buffer fills up on the second record
+		w, wl := newBufferedWAL(t, dir, size+1)
+		defer w.Close()
+		requireLog(t, w, newTestRecord(1, "line"))
+		require.Zero(t, wl.attempts)
+		requireLog(t, w, newTestRecord(1, "line"))
+		require.Equal(t, 1, wl.attempts)
+	})
+
+	t.Run("flushed before rotating", func(t *testing.T) {
+		dir := t.TempDir()
+		w, _ := newBufferedWAL(t, dir, 1<<20)
+		requireLog(t, w, newTestRecord(1, "first"))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+		requireLog(t, w, newTestRecord(1, "second"))
+		require.NoError(t, w.DeleteSegment(0))
+		require.NoError(t, w.Close())
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"second"}, lines)
+	})
+}
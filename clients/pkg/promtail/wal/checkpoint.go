@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkpointName is the name of the file under the WAL directory the checkpoint is persisted in. Since it isn't a
+// number, it's never listed as a segment, and unlike wlog checkpoints it has no dot, so wlog never picks it up either.
+const checkpointName = "checkpoint"
+
+// Checkpoint durably records that all records in the segments numbered strictly lower than upToSegment were delivered,
+// so that Replay and Drain skip their entries, including after a restart. Their series are still replayed, since
+// entries of later segments may reference them, so checkpointed segments are still read. Truncate advances the
+// checkpoint too. The checkpoint can be moved back, but never after the segment currently being written to, whose
+// records may still be undelivered. Delete removes it along with the WAL directory.
+//
+// Delivery is tracked per segment only, so records logged to upToSegment before the checkpoint are replayed again.
+// Compact may move records of checkpointed segments after the checkpoint, which are replayed again as well.
+func (w *wrapper) Checkpoint(upToSegment int) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	current, err := w.currentSegment()
+	if err != nil {
+		return err
+	}
+	if upToSegment < 0 || upToSegment > current {
+		return fmt.Errorf("invalid WAL checkpoint segment %d: must be between 0 and the current segment %d", upToSegment, current)
+	}
+	return w.writeCheckpoint(upToSegment)
+}
+
+// advanceCheckpoint moves the checkpoint to upToSegment, if it's before it. Must be called with mtx held.
+func (w *wrapper) advanceCheckpoint(upToSegment int) error {
+	checkpoint, err := w.readCheckpoint()
+	if err != nil || checkpoint >= upToSegment {
+		return err
+	}
+	return w.writeCheckpoint(upToSegment)
+}
+
+// readCheckpoint returns the segment recorded by the last checkpoint, or 0 if there's none, so that everything is
+// replayed. Must be called with mtx held, either for reading or writing.
+func (w *wrapper) readCheckpoint() (int, error) {
+	return readCheckpointFile(w.wal.Dir())
+}
+
+// readCheckpointFile is readCheckpoint, for the WAL in dir.
+func readCheckpointFile(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading WAL checkpoint: %w", err)
+	}
+	segment, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || segment < 0 {
+		return 0, fmt.Errorf("invalid WAL checkpoint %q", data)
+	}
+	return segment, nil
+}
+
+// writeCheckpoint persists upToSegment as the checkpoint, atomically replacing the previous one, and syncs the WAL
+// directory so that the replacement survives a crash regardless of Config.FsyncDir. Must be called with mtx held.
+func (w *wrapper) writeCheckpoint(upToSegment int) error {
+	f, err := os.CreateTemp(w.wal.Dir(), ".checkpoint-")
+	if err != nil {
+		return fmt.Errorf("error creating WAL checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strconv.Itoa(upToSegment) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), w.checkpointPath())
+	}
+	if err == nil {
+		err = w.syncDir(w.wal.Dir())
+	}
+	if err != nil {
+		return fmt.Errorf("error writing WAL checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (w *wrapper) checkpointPath() string {
+	return filepath.Join(w.wal.Dir(), checkpointName)
+}
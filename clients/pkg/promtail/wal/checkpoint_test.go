@@ -0,0 +1,131 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestWAL_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"first"}, 1: {"second"}, 2: {"third"}})
+
+	// without a checkpoint, everything is replayed
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second", "third"}, lines)
+
+	require.NoError(t, w.(Extended).Checkpoint(2))
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"third"}, lines)
+	require.EqualError(t, w.(Extended).Checkpoint(3), "invalid WAL checkpoint segment 3: must be between 0 and the current segment 2")
+
+	// the checkpoint survives a restart
+	require.NoError(t, w.Close())
+	w, err = New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"third"}, lines)
+
+	// and can be moved back
+	require.NoError(t, w.(Extended).Checkpoint(1))
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"second", "third"}, lines)
+}
+
+func TestWAL_CheckpointInvalid(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"first"}})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, checkpointName), []byte("garbage"), 0o644))
+
+	_, err := replayLines(w)
+	require.EqualError(t, err, `invalid WAL checkpoint "garbage"`)
+}
+
+func TestWAL_TruncateAdvancesCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"first"}, 1: {"second"}, 2: {"third"}, 3: {"fourth"}})
+	ww := w.(*wrapper)
+
+	// an open reader keeps the segments from being removed, but their records are still delivered
+	reader, err := ww.NewReader()
+	require.NoError(t, err)
+	require.NoError(t, w.Truncate(2))
+	require.Equal(t, []int{0, 1, 2, 3}, segmentNumbers(t, dir))
+	checkpoint, err := ww.readCheckpoint()
+	require.NoError(t, err)
+	require.Equal(t, 2, checkpoint)
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"third", "fourth"}, lines)
+	require.NoError(t, reader.Close())
+
+	// truncating never moves the checkpoint back, nor after the current segment
+	require.NoError(t, w.Truncate(1))
+	require.NoError(t, w.Truncate(10))
+	checkpoint, err = ww.readCheckpoint()
+	require.NoError(t, err)
+	require.Equal(t, 3, checkpoint)
+}
+
+func TestWAL_DrainSkipsCheckpointedSegments(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"first"}, 1: {"second"}, 2: {"third"}})
+	ww := w.(Extended)
+	require.NoError(t, ww.Checkpoint(1))
+
+	var lines []string
+	require.NoError(t, ww.Drain(func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			for _, entry := range entries.Entries {
+				lines = append(lines, entry.Line)
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"second", "third"}, lines)
+	// the delivered segment is removed too
+	require.Equal(t, []int{3}, segmentNumbers(t, dir))
+}
+
+func TestWAL_CheckpointKeepsSeries(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"first"}, 1: {"second"}})
+	ww := w.(Extended)
+	// entries of series 1, whose series record is in the checkpointed segment 0
+	rec := newTestRecord(1, "third")
+	rec.Series = nil
+	require.NoError(t, ww.LogToSegment(1, rec))
+	require.NoError(t, ww.Checkpoint(1))
+
+	var series []string
+	var lines []string
+	require.NoError(t, w.Replay(func(rec *wal.Record) error {
+		for _, s := range rec.Series {
+			series = append(series, s.Labels.String())
+		}
+		for _, entries := range rec.RefEntries {
+			for _, entry := range entries.Entries {
+				lines = append(lines, entry.Line)
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{`{test="series-1"}`, `{test="series-2"}`}, series)
+	require.Equal(t, []string{"second", "third"}, lines)
+
+	// merging into the checkpointed WAL doesn't reuse the refs of its checkpointed series
+	next, err := nextSeriesRef(w)
+	require.NoError(t, err)
+	require.Equal(t, chunks.HeadSeriesRef(3), next)
+}
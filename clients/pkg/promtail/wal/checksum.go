@@ -0,0 +1,52 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// ErrChecksumMismatch is returned when reading back a record whose checksum doesn't match its contents, when
+// Config.RecordChecksums is enabled.
+var ErrChecksumMismatch = errors.New("wal record checksum mismatch")
+
+// checksumSize is the size in bytes of the CRC32 prepended to each record when Config.RecordChecksums is enabled.
+const checksumSize = 4
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecord appends to buf the record produced by encode, prefixed with its checksum if checksums are enabled.
+func encodeRecord(buf []byte, checksums bool, encode func([]byte) []byte) []byte {
+	if !checksums {
+		return encode(buf)
+	}
+	start := len(buf)
+	buf = encode(append(buf, make([]byte, checksumSize)...))
+	binary.BigEndian.PutUint32(buf[start:], crc32.Checksum(buf[start+checksumSize:], castagnoliTable))
+	return buf
+}
+
+// decodeRecord decodes b, verifying and stripping its checksum first if checksums are enabled. The record is decoded
+// into rec, unless a custom encoder is given, in which case the record decoded by it is returned instead.
+func decodeRecord(b []byte, rec *wal.Record, checksums bool, encoder Encoder) (*wal.Record, error) {
+	if checksums {
+		if len(b) < checksumSize {
+			return nil, fmt.Errorf("%w: record of %d bytes is too short to hold a checksum", ErrChecksumMismatch, len(b))
+		}
+		expected, actual := binary.BigEndian.Uint32(b), crc32.Checksum(b[checksumSize:], castagnoliTable)
+		if expected != actual {
+			return nil, fmt.Errorf("%w: expected %08x, got %08x", ErrChecksumMismatch, expected, actual)
+		}
+		b = b[checksumSize:]
+	}
+	if encoder != nil {
+		return encoder.Decode(b)
+	}
+	if err := wal.DecodeRecord(b, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
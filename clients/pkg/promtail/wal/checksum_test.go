@@ -0,0 +1,47 @@
+package wal
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_RecordChecksums(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, RecordChecksums: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.NoError(t, w.Close())
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+
+	// flip the last byte of the first record payload after the version header, fixing up the wlog checksum so that
+	// only the record checksum can detect it. wlog record headers are a type byte, a 2 bytes length and a 4 bytes CRC32.
+	segment := filepath.Join(dir, "00000000")
+	content, err := os.ReadFile(segment)
+	require.NoError(t, err)
+	rec := content[versionRecordSize:]
+	length := int(binary.BigEndian.Uint16(rec[1:3]))
+	payload := rec[7 : 7+length]
+	payload[length-1] ^= 0xff
+	binary.BigEndian.PutUint32(rec[3:7], crc32.Checksum(payload, castagnoliTable))
+	require.NoError(t, os.WriteFile(segment, content, 0o644))
+
+	_, err = replayLines(w)
+	var corrupted *CorruptedSegmentsError
+	require.ErrorAs(t, err, &corrupted)
+	require.ErrorIs(t, corrupted.Errs[0], ErrChecksumMismatch)
+
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+	require.False(t, r.Next())
+	require.ErrorIs(t, r.Err(), ErrChecksumMismatch)
+}
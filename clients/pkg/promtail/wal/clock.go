@@ -0,0 +1,52 @@
+package wal
+
+import "time"
+
+// clock abstracts time for the time-based WAL features, such as interval syncing and retention, so that tests can
+// control it.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the subset of time.Ticker used by the WAL.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the clock used by default, backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// walOption customizes a wrapper built by newWAL.
+type walOption func(*wrapper)
+
+// withSyncer makes the WAL be synced by s when running in SyncModeInterval. A nil s is ignored.
+func withSyncer(s *Syncer) walOption {
+	return func(w *wrapper) {
+		w.syncer = s
+	}
+}
+
+// withClock makes the WAL use c instead of the real clock.
+func withClock(c clock) walOption {
+	return func(w *wrapper) {
+		w.clock = c
+	}
+}
@@ -0,0 +1,196 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock whose time only moves when advanced. Tickers created from it fire when advancing past their
+// next tick.
+type fakeClock struct {
+	mtx     sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1_000_000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing any ticker due. Like time.Ticker, ticks are dropped if the previous one
+// wasn't consumed yet.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	t.stopped = true
+}
+
+func TestWAL_IntervalSyncWithFakeClock(t *testing.T) {
+	clk := newFakeClock()
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:      true,
+		Dir:          t.TempDir(),
+		SyncMode:     SyncModeInterval,
+		SyncInterval: time.Minute,
+	}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+	rec := injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} })
+
+	requireLog(t, w, newTestRecord(1, "line"))
+	clk.Advance(59 * time.Second)
+	require.Equal(t, 0, rec.count("sync"))
+
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool { return rec.count("sync") == 1 }, time.Second, time.Millisecond)
+
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool { return rec.count("sync") == 2 }, time.Second, time.Millisecond)
+}
+
+func TestWAL_TimeBasedFeaturesWithFakeClock(t *testing.T) {
+	clk := newFakeClock()
+	dir := t.TempDir()
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: dir}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.Equal(t, clk.Now(), w.LastWriteTime())
+
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	for segment, modTime := range map[int]time.Time{0: clk.Now(), 1: clk.Now()} {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, fmt.Sprintf("%08d", segment)), modTime, modTime))
+	}
+
+	deleted, err := w.DeleteOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+
+	clk.Advance(2 * time.Hour)
+	deleted, err = w.DeleteOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Equal(t, []int{1}, segmentNumbers(t, dir))
+}
+
+func TestWAL_DiskSizeMetric(t *testing.T) {
+	clk := newFakeClock()
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:                true,
+		Dir:                    t.TempDir(),
+		DiskSizeUpdateInterval: time.Minute,
+	}, "client", "tenant", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+	diskSize := func() int64 {
+		return int64(testutil.ToFloat64(w.(*wrapper).metrics.diskSize.WithLabelValues("client", "tenant")))
+	}
+
+	// the first write always updates the metric
+	requireLog(t, w, newTestRecord(1, "first"))
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.NotZero(t, size)
+	require.Equal(t, size, diskSize())
+
+	// writes within the update interval don't
+	requireLog(t, w, newTestRecord(1, "second"))
+	require.Equal(t, size, diskSize())
+
+	clk.Advance(time.Minute)
+	requireLog(t, w, newTestRecord(1, "third"))
+	size, err = w.Size()
+	require.NoError(t, err)
+	require.Equal(t, size, diskSize())
+}
+
+func TestWAL_OldestSegmentAgeMetric(t *testing.T) {
+	clk := newFakeClock()
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	w, err := newWAL(log.NewNopLogger(), reg, Config{Enabled: true, Dir: dir}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(*wrapper)
+	age := func() float64 {
+		return testutil.ToFloat64(ww.metrics.oldestSegmentAge)
+	}
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "00000000"), clk.Now(), clk.Now().Add(-time.Hour)))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "00000001"), clk.Now(), clk.Now().Add(-time.Minute)))
+
+	// the age is computed when scraped, so it grows without anything being logged
+	require.Equal(t, time.Hour.Seconds(), age())
+	clk.Advance(time.Minute)
+	require.Equal(t, (time.Hour + time.Minute).Seconds(), age())
+
+	// checkpointed segments are delivered, so the next one is the oldest
+	require.NoError(t, ww.Checkpoint(1))
+	require.Equal(t, (2 * time.Minute).Seconds(), age())
+
+	// closed WALs aren't exposed
+	require.NoError(t, w.Close())
+	count, err := testutil.GatherAndCount(reg, "promtail_wal_oldest_segment_age_seconds")
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,117 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// compactDir is the directory under the WAL directory compacted segments are written to before replacing the
+// originals. Since it isn't named as a number, it's never listed as a segment.
+const compactDir = ".compact"
+
+// Compact rewrites all segments but the one currently being written into as few full-size segments as possible,
+// preserving record order, which makes replays of a fragmented WAL cheaper. Records are copied as they're stored, so
+// checksums and encodings are kept. Writes are blocked while compacting.
+//
+// Compacted segments replace the last ones of the originals, from last to first, and the remaining originals are only
+// removed afterwards, so that every record is always persisted. A crash in the middle of a compaction can only leave
+// records duplicated. Metadata sidecars of compacted segments are removed, since they no longer describe their
+// contents, and removed originals aren't notified to Config.OnSegmentDelete, nor mirrored, since no record is lost.
+func (w *wrapper) Compact() error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	// the last segment is the head, which is still being written
+	if len(segments) < 3 {
+		return nil
+	}
+	segments = segments[:len(segments)-1]
+
+	tmpDir := filepath.Join(w.wal.Dir(), compactDir)
+	// a previous compaction might have crashed before replacing any segment
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return fmt.Errorf("error removing previous compaction: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	compacted, err := w.copySegments(tmpDir, segments)
+	if err != nil {
+		return fmt.Errorf("error compacting segments: %w", err)
+	}
+	if len(compacted) >= len(segments) {
+		return nil
+	}
+
+	targets := segments[len(segments)-len(compacted):]
+	for i := len(compacted) - 1; i >= 0; i-- {
+		target := targets[i]
+		if err := fileutil.Rename(wlog.SegmentName(tmpDir, compacted[i]), filepath.Join(w.wal.Dir(), target.name)); err != nil {
+			return fmt.Errorf("error replacing segment %d: %w", target.number, err)
+		}
+		w.removeSegmentMeta(target.number)
+	}
+	for _, segment := range segments[:len(segments)-len(compacted)] {
+		if err := w.fs.Remove(filepath.Join(w.wal.Dir(), segment.name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing compacted segment %d: %w", segment.number, err)
+		}
+		w.removeSegmentMeta(segment.number)
+	}
+	level.Info(w.log).Log("msg", "compacted WAL segments", "from", len(segments), "to", len(compacted))
+	return w.dirChanged()
+}
+
+// copySegments copies the records of segments in order to a new WAL in dir, returning the numbers of the segments it
+// created. Must be called with mtx held.
+func (w *wrapper) copySegments(dir string, segments []segmentRef) ([]int, error) {
+	tmp, err := wlog.NewSize(w.log, nil, dir, w.cfg.segmentSize(), w.cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		if err := copySegment(tmp, filepath.Join(w.wal.Dir(), segment.name)); err != nil {
+			_ = tmp.Close()
+			return nil, fmt.Errorf("error copying segment %d: %w", segment.number, err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	created, err := readSegmentRefs(OSFS{}, dir)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]int, 0, len(created))
+	for _, segment := range created {
+		numbers = append(numbers, segment.number)
+	}
+	return numbers, nil
+}
+
+// copySegment logs to dst all records in the segment file at path, as they're stored.
+func copySegment(dst *wlog.WL, path string) error {
+	segment, err := wlog.OpenReadSegment(path)
+	if err != nil {
+		return err
+	}
+	defer segment.Close()
+	reader := wlog.NewReader(wlog.NewSegmentBufReader(segment))
+	for reader.Next() {
+		if err := dst.Log(reader.Record()); err != nil {
+			return err
+		}
+	}
+	return reader.Err()
+}
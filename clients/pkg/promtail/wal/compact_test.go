@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_Compact(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize, RecordChecksums: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+
+	var expected []string
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 3; j++ {
+			line := fmt.Sprintf("line %d-%d", i, j)
+			expected = append(expected, line)
+			requireLog(t, w, newTestRecord(uint64(i), line))
+		}
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	requireLog(t, w, newTestRecord(6, "head"))
+	expected = append(expected, "head")
+	require.NoError(t, ww.WriteSegmentMeta(5, []byte("stale")))
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6}, segmentNumbers(t, dir))
+
+	require.NoError(t, ww.Compact())
+	// all closed segments fit in one, which replaces the last of them
+	require.Equal(t, []int{5, 6}, segmentNumbers(t, dir))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, expected, lines)
+	_, err = ww.ReadSegmentMeta(5)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoDirExists(t, filepath.Join(dir, compactDir))
+
+	// compacting again is a no-op, and the WAL is still writable and reopens cleanly
+	require.NoError(t, ww.Compact())
+	require.Equal(t, []int{5, 6}, segmentNumbers(t, dir))
+	requireLog(t, w, newTestRecord(7, "after compaction"))
+	require.NoError(t, w.Close())
+	w, err = New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize, RecordChecksums: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, append(expected, "after compaction"), lines)
+}
+
+func TestWAL_CompactIntoSeveralSegments(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// each segment holds a bit over a third of a full segment, so they can only be merged in pairs
+	var expected []string
+	for i := 0; i < 6; i++ {
+		line := fmt.Sprintf("%d %0*d", i, minSegmentSize/3, 0)
+		expected = append(expected, line)
+		requireLog(t, w, newTestRecord(uint64(i), line))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.(Extended).Compact())
+	require.Equal(t, []int{3, 4, 5, 6}, segmentNumbers(t, dir))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, expected, lines)
+}
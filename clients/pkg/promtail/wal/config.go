@@ -1,11 +1,40 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
 )
 
 const (
 	defaultMaxSegmentAge = time.Hour
+	// defaultDiskSizeUpdateInterval is used when Config.DiskSizeUpdateInterval is not set.
+	defaultDiskSizeUpdateInterval = 10 * time.Second
+	// defaultSlowOpenThreshold is used when Config.SlowOpenThreshold is not set.
+	defaultSlowOpenThreshold = 10 * time.Second
+
+	// minSegmentSize is the smallest segment size accepted in Config.SegmentSize.
+	minSegmentSize = 1024 * 1024 // 1MB
+	// walPageSize mirrors the page size used by wlog, which segment sizes must be a multiple of.
+	walPageSize = 32 * 1024 // 32KB
+)
+
+// SyncMode controls when writes to the WAL are flushed to disk.
+type SyncMode string
+
+const (
+	// SyncModeManual never syncs the WAL implicitly, leaving it to callers of Sync. This is the default.
+	SyncModeManual SyncMode = "manual"
+	// SyncModePerRecord syncs the WAL after every record is logged.
+	SyncModePerRecord SyncMode = "perRecord"
+	// SyncModeInterval syncs the WAL periodically, every Config.SyncInterval.
+	SyncModeInterval SyncMode = "interval"
 )
 
 // Config contains all WAL-related settings.
@@ -23,6 +52,171 @@ type Config struct {
 	//
 	// Note that this functionality will likely be deprecated in favour of a programmatic cleanup mechanism.
 	MaxSegmentAge time.Duration `yaml:"cleanSegmentsOlderThan"`
+
+	// SegmentSize is the size in bytes at which the WAL rotates to a new segment. If zero, wlog.DefaultSegmentSize is
+	// used. It must be at least 1MB, and a multiple of 32KB.
+	SegmentSize int `yaml:"segmentSize"`
+
+	// Compression enables snappy compression of the records written to the WAL.
+	Compression bool `yaml:"compression"`
+
+	// RecordBufferSize is the initial capacity in bytes of the buffers records are encoded into before being written.
+	// Setting it close to the typical encoded record size avoids growing buffers when logging large batches. If zero,
+	// buffers start at 1KB.
+	RecordBufferSize int `yaml:"recordBufferSize"`
+
+	// MaxSize is the maximum total size in bytes of the WAL segments. When exceeded after logging a record, the oldest
+	// segments are evicted until the WAL is under the limit again. The segment currently being written is never
+	// evicted. If zero, the WAL size is unbounded. Note that enforcing it requires reading the WAL directory after each
+	// write. When set, a write failing because the disk is full also evicts the oldest segment and is retried once.
+	MaxSize int64 `yaml:"maxSize"`
+
+	// MaxSegments is the maximum number of segments in the WAL. When exceeded after rotating to a new segment, or after
+	// logging a record, the oldest segments are evicted until the WAL is within the limit again. The segment currently
+	// being written is never evicted. It can be combined with MaxSize, in which case both limits are enforced. If zero,
+	// the number of segments is unbounded.
+	MaxSegments int `yaml:"maxSegments"`
+
+	// SyncMode controls when writes to the WAL are flushed to disk. Defaults to SyncModeManual.
+	SyncMode SyncMode `yaml:"syncMode"`
+
+	// SyncInterval is the period at which the WAL is synced when using SyncModeInterval.
+	SyncInterval time.Duration `yaml:"syncInterval"`
+
+	// WriteRetries is the number of times a write to the WAL failing with a transient error, such as a full disk or an
+	// interrupted system call, is retried before giving up, waiting with an exponential backoff between retries. Other
+	// errors are never retried. Retries wait without holding any WAL lock, and records buffered with BufferSize stay
+	// buffered until their flush succeeds. Note that records partially written before the failure may be written again
+	// by a retry, so replaying the WAL may yield them twice. If zero, writes aren't retried.
+	WriteRetries int `yaml:"writeRetries"`
+
+	// WriteRetryBackoff is the wait before the first write retry, doubled on each following retry up to 1s. Default:
+	// 10ms.
+	WriteRetryBackoff time.Duration `yaml:"writeRetryBackoff"`
+
+	// BufferSize, if set, makes Log keep encoded records in memory until they add up to that many bytes, then write
+	// them to the WAL at once, trading durability for fewer writes. The buffer is also flushed by Flush, Sync, Close
+	// and before rotating segments. Buffered records are lost if the process crashes, or if flushing them fails, and
+	// aren't seen by Replay, readers or size stats until flushed. It can't be combined with SyncModePerRecord. If zero,
+	// records are written as they're logged.
+	BufferSize int `yaml:"bufferSize"`
+
+	// ReadOnly opens an existing WAL directory for reading only, with Replay, readers and stats, without creating a new
+	// segment as done when opening a WAL for writing. All operations modifying the WAL, such as Log, NextSegment,
+	// DeleteSegment or Delete, return ErrReadOnly. It can't be combined with RepairOnOpen.
+	ReadOnly bool `yaml:"readOnly"`
+
+	// RepairOnOpen makes the WAL check for corrupted records when it's created, trimming the corrupted segment if
+	// needed. See Repair for details.
+	RepairOnOpen bool `yaml:"repairOnOpen"`
+
+	// RecordChecksums prefixes each record with a CRC32 of its contents, verified when reading it back with Replay or
+	// a RecordReader, which return ErrChecksumMismatch on a mismatch. This catches corruption within a record that
+	// segment level checks miss. It changes the on-disk format, so it must not be toggled on an existing WAL, nor
+	// enabled on a WAL read by a Watcher, which doesn't expect checksums. NewWriter rejects it for that reason.
+	RecordChecksums bool `yaml:"recordChecksums"`
+
+	// DeterministicEncoding sorts the labels of each series by name before encoding them, so that the same records are
+	// always encoded to the same bytes even if their labels were built out of order, which helps golden-file tests.
+	// Labels are usually sorted already, so it's off by default to avoid checking them on every write. A custom Encoder
+	// ignores it.
+	DeterministicEncoding bool `yaml:"deterministicEncoding"`
+
+	// EntriesRecordVersion is the version of the entries records written to the WAL, which can be set to an older one
+	// for compatibility with readers pinned to an older Loki. Either wal.WALRecordEntriesV1 or wal.WALRecordEntriesV2
+	// is supported, V1 not keeping the entries counter. Default: wal.CurrentEntriesRec. A custom Encoder ignores it.
+	EntriesRecordVersion wal.RecordType `yaml:"entriesRecordVersion"`
+
+	// Encoder optionally overrides how records are encoded when logged, and decoded by Replay and RecordReader. If nil,
+	// records are encoded as DefaultEncoder does, reusing pooled buffers. Like RecordChecksums, a custom encoder must not
+	// be used on a WAL read by a Watcher.
+	Encoder Encoder `yaml:"-"`
+
+	// DiskSizeUpdateInterval is the minimum period between updates of the WAL disk size metric, which are made after
+	// logging records and require reading the WAL directory. Default: 10s.
+	DiskSizeUpdateInterval time.Duration `yaml:"diskSizeUpdateInterval"`
+
+	// SlowOpenThreshold is how long opening the WAL, including the initial scan of its segments, can take before a
+	// warning is logged. Open durations are exposed in the promtail_wal_open_duration_seconds metric. Default: 10s.
+	SlowOpenThreshold time.Duration `yaml:"slowOpenThreshold"`
+
+	// StatCacheTTL, if set, makes Size, CountSegments, Segments, Stats and the MaxSize eviction reuse a snapshot of the
+	// WAL directory listing for that long, instead of reading the directory and stating every segment on each call,
+	// which can be slow on network filesystems. Within the TTL, sizes and counts may be stale, except that creating or
+	// removing segments through the WAL refreshes the snapshot. If zero, the directory is read on every call.
+	StatCacheTTL time.Duration `yaml:"statCacheTTL"`
+
+	// MaxRecordAge, if set, makes Replay and Drain skip the records whose newest entry is older than that when the
+	// replay starts, since Loki would reject them anyway. Records holding no entries, such as series records, are never
+	// skipped, and records holding both series and stale entries are replayed with their entries removed. Skipped
+	// records are counted in the promtail_wal_stale_records_skipped_total metric. If zero, all records are replayed.
+	MaxRecordAge time.Duration `yaml:"maxRecordAge"`
+
+	// DryRun makes the WAL encode and validate records as usual, but skip writing them, so no segments are created.
+	// Encoded bytes are counted in a separate metric. Unlike a disabled WAL, this exercises the whole encoding path,
+	// which is useful for benchmarking it.
+	DryRun bool `yaml:"dryRun"`
+
+	// StrictEmpty makes logging a record with neither series nor entries fail with ErrEmptyRecord, instead of silently
+	// succeeding without writing anything, to catch producers building empty records. In LogAll, the records before the
+	// empty one are still written, while LogBatch fails without writing anything. Records dropped by LogHook don't count.
+	StrictEmpty bool `yaml:"strictEmpty"`
+
+	// MirrorDir optionally sets a second directory, ideally on another disk, all writes are mirrored to, with the same
+	// layout as Dir. Errors writing to the mirror are logged and counted, but don't fail the WAL. Segment removals are
+	// mirrored by name, and the mirror is never repaired, so it's meant as a copy to recover from, not to be read live.
+	MirrorDir string `yaml:"mirrorDir"`
+
+	// ArchiveDir optionally sets a directory, with the same layout as Dir, segments evicted to enforce MaxSize or
+	// MaxSegments, or removed by DeleteOlderThan, are gzipped into before being removed, named after their number with
+	// a ".gz" suffix. A segment failing to be archived is kept. Archived segments can be restored with RestoreArchive.
+	// Segments evicted to free space when the disk is full, or removed by DeleteSegment or Truncate, aren't archived.
+	ArchiveDir string `yaml:"archiveDir"`
+
+	// FsyncDir makes the WAL fsync its directory after rotating to a new segment with NextSegment or LogBatch, or after
+	// removing segments, so that those changes survive a crash. Segments created by wlog when the current one fills up
+	// aren't covered.
+	FsyncDir bool `yaml:"fsyncDir"`
+
+	// Preallocate makes the WAL reserve the disk space of each segment when it starts being written, avoiding
+	// fragmentation on filesystems supporting it. The apparent size of segment files is unchanged, since wlog appends
+	// records at the end of the file, so extending it to the full segment size would break writes. It requires reading
+	// the WAL directory after each write, to catch segments rotated by wlog.
+	Preallocate bool `yaml:"preallocate"`
+
+	// OnSegmentDelete is optionally called with the number of each segment removed by DeleteSegment, Truncate,
+	// DeleteOlderThan, or evicted to enforce MaxSize or MaxSegments. It's called after the removal, without holding any
+	// WAL lock.
+	OnSegmentDelete func(segmentNum int) `yaml:"-"`
+
+	// OnSegmentCreate is optionally called with the number of each segment the WAL rotates to with NextSegment or
+	// Rotate, or before logging a batch with LogBatch or draining with Drain. Segments wlog rotates to on its own when
+	// the current one fills up aren't notified. It's called after the rotation, without holding any WAL lock.
+	OnSegmentCreate func(segmentNum int) `yaml:"-"`
+
+	// LogHook is optionally called with each record passed to Log, LogContext, LogTracked, LogAll or LogBatch before it's
+	// written. The record it returns is written instead, and nothing is written if it returns false, in which case the
+	// call succeeds without writing.
+	LogHook func(record *wal.Record) (*wal.Record, bool) `yaml:"-"`
+
+	// FS optionally overrides the filesystem segments are listed, inspected and removed through. If nil, OSFS is used.
+	FS FS `yaml:"-"`
+
+	// SegmentNameFunc and SegmentNumberFunc optionally override how this package names segments, and recognizes them
+	// and parses their numbers from file names, so that WALs whose segments were renamed for external tools can be
+	// listed, read and cleaned up. Both must be set together, and be the inverse of each other. Metadata sidecars are
+	// named after their segment, plus a ".meta" suffix, which SegmentNumberFunc must reject. Note that wlog still
+	// writes new segments with its own 8 digits zero padded names, which are ignored unless SegmentNumberFunc
+	// recognizes them, and that Watch, which follows the segments written by wlog, expects those names. If nil,
+	// segments are named as wlog does, and any file with a decimal number as name is a segment.
+	SegmentNameFunc   func(segmentNum int) string   `yaml:"-"`
+	SegmentNumberFunc func(name string) (int, bool) `yaml:"-"`
+
+	// DirFunc optionally overrides the directory layout of the WAL for a given client and tenant. The returned path must
+	// be under base. If nil, the WAL is written to base/clientName/tenantID, and client names or tenant IDs that aren't
+	// a single path element, such as "a/b" or "..", are rejected. DirFunc receives them unchecked, so that it can escape
+	// them as it sees fit.
+	DirFunc func(base, clientName, tenantID string) string `yaml:"-"`
 }
 
 // UnmarshalYAML implement YAML Unmarshaler
@@ -32,3 +226,176 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain Config
 	return unmarshal((*plain)(c))
 }
+
+// Validate checks the configuration is valid.
+func (c *Config) Validate() error {
+	if c.Enabled && c.Dir == "" {
+		return errors.New("invalid WAL dir: must be set when the WAL is enabled")
+	}
+	if c.SyncInterval < 0 {
+		return fmt.Errorf("invalid WAL sync interval %v: must not be negative", c.SyncInterval)
+	}
+	switch c.SyncMode {
+	case "", SyncModeManual, SyncModePerRecord:
+	case SyncModeInterval:
+		if c.SyncInterval <= 0 {
+			return fmt.Errorf("invalid WAL sync interval %v: must be positive when using sync mode %q", c.SyncInterval, c.SyncMode)
+		}
+	default:
+		return fmt.Errorf("invalid WAL sync mode %q", c.SyncMode)
+	}
+	if (c.SegmentNameFunc == nil) != (c.SegmentNumberFunc == nil) {
+		return errors.New("invalid WAL config: segment name and number functions must be set together")
+	}
+	if c.ReadOnly && c.RepairOnOpen {
+		return errors.New("invalid WAL config: repairOnOpen can't be enabled on a read-only WAL")
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("invalid WAL max size %d: must not be negative", c.MaxSize)
+	}
+	if c.ArchiveDir != "" && filepath.Clean(c.ArchiveDir) == filepath.Clean(c.Dir) {
+		return fmt.Errorf("invalid WAL archive dir %q: must be different from the WAL dir", c.ArchiveDir)
+	}
+	if c.MaxSegments < 0 {
+		return fmt.Errorf("invalid WAL max segments %d: must not be negative", c.MaxSegments)
+	}
+	if c.MirrorDir != "" && filepath.Clean(c.MirrorDir) == filepath.Clean(c.Dir) {
+		return fmt.Errorf("invalid WAL mirror dir %q: must be different from the WAL dir", c.MirrorDir)
+	}
+	if c.DiskSizeUpdateInterval < 0 {
+		return fmt.Errorf("invalid WAL disk size update interval %v: must not be negative", c.DiskSizeUpdateInterval)
+	}
+	switch c.EntriesRecordVersion {
+	case 0, wal.WALRecordEntriesV1, wal.WALRecordEntriesV2:
+	default:
+		return fmt.Errorf("invalid WAL entries record version %d: must be %d or %d", c.EntriesRecordVersion, wal.WALRecordEntriesV1, wal.WALRecordEntriesV2)
+	}
+	if c.SlowOpenThreshold < 0 {
+		return fmt.Errorf("invalid WAL slow open threshold %v: must not be negative", c.SlowOpenThreshold)
+	}
+	if c.StatCacheTTL < 0 {
+		return fmt.Errorf("invalid WAL stat cache TTL %v: must not be negative", c.StatCacheTTL)
+	}
+	if c.MaxRecordAge < 0 {
+		return fmt.Errorf("invalid WAL max record age %v: must not be negative", c.MaxRecordAge)
+	}
+	if c.WriteRetries < 0 {
+		return fmt.Errorf("invalid WAL write retries %d: must not be negative", c.WriteRetries)
+	}
+	if c.WriteRetryBackoff < 0 {
+		return fmt.Errorf("invalid WAL write retry backoff %v: must not be negative", c.WriteRetryBackoff)
+	}
+	if c.RecordBufferSize < 0 {
+		return fmt.Errorf("invalid WAL record buffer size %d: must not be negative", c.RecordBufferSize)
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("invalid WAL buffer size %d: must not be negative", c.BufferSize)
+	}
+	if c.BufferSize > 0 && c.SyncMode == SyncModePerRecord {
+		return fmt.Errorf("invalid WAL buffer size %d: must be zero with sync mode %q", c.BufferSize, c.SyncMode)
+	}
+	if c.SegmentSize < 0 {
+		return fmt.Errorf("invalid WAL segment size %d: must not be negative", c.SegmentSize)
+	}
+	if c.SegmentSize != 0 {
+		if c.SegmentSize < minSegmentSize {
+			return fmt.Errorf("invalid WAL segment size %d: must be at least %d bytes", c.SegmentSize, minSegmentSize)
+		}
+		if c.SegmentSize%walPageSize != 0 {
+			return fmt.Errorf("invalid WAL segment size %d: must be a multiple of %d bytes", c.SegmentSize, walPageSize)
+		}
+		if c.MaxSize > 0 && int64(c.SegmentSize) > c.MaxSize {
+			return fmt.Errorf("invalid WAL segment size %d: must not exceed the WAL max size %d, or the WAL would evict segments as soon as they're created", c.SegmentSize, c.MaxSize)
+		}
+	}
+	return nil
+}
+
+// walDir resolves the directory the WAL of the given client and tenant is written to.
+func (c *Config) walDir(clientName, tenantID string) (string, error) {
+	return c.dirUnder(c.Dir, clientName, tenantID)
+}
+
+// dirUnder resolves the WAL directory for clientName and tenantID under base, applying DirFunc if set.
+func (c *Config) dirUnder(base, clientName, tenantID string) (string, error) {
+	if c.DirFunc == nil {
+		if err := checkPathElement("client name", clientName); err != nil {
+			return "", err
+		}
+		if err := checkPathElement("tenant ID", tenantID); err != nil {
+			return "", err
+		}
+		return filepath.Join(base, clientName, tenantID), nil
+	}
+	dir := c.DirFunc(base, clientName, tenantID)
+	if dir == "" {
+		return "", fmt.Errorf("WAL directory for client %q and tenant %q is empty", clientName, tenantID)
+	}
+	rel, err := filepath.Rel(base, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("WAL directory %q for client %q and tenant %q is not under %q", dir, clientName, tenantID, base)
+	}
+	return dir, nil
+}
+
+// checkPathElement returns an error if name, a client name or tenant ID of the given kind, can't be safely used as a
+// single element of the WAL directory path. Empty names are allowed, and left out of the path.
+func checkPathElement(kind, name string) error {
+	switch {
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid WAL %s %q: must not be a relative path element", kind, name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("invalid WAL %s %q: must not contain path separators", kind, name)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("invalid WAL %s %q: must not contain null bytes", kind, name)
+	}
+	return nil
+}
+
+// segmentSize returns the configured segment size, falling back to wlog.DefaultSegmentSize when unset.
+func (c *Config) segmentSize() int {
+	if c.SegmentSize > 0 {
+		return c.SegmentSize
+	}
+	return wlog.DefaultSegmentSize
+}
+
+// filesystem returns the configured FS, or OSFS if not set.
+func (c *Config) filesystem() FS {
+	if c.FS != nil {
+		return c.FS
+	}
+	return OSFS{}
+}
+
+// writeRetryBackoff returns the configured WriteRetryBackoff, or the default one if not set.
+func (c *Config) writeRetryBackoff() time.Duration {
+	if c.WriteRetryBackoff == 0 {
+		return defaultWriteRetryBackoff
+	}
+	return c.WriteRetryBackoff
+}
+
+// entriesRecordVersion returns the configured EntriesRecordVersion, or wal.CurrentEntriesRec if not set.
+func (c *Config) entriesRecordVersion() wal.RecordType {
+	if c.EntriesRecordVersion == 0 {
+		return wal.CurrentEntriesRec
+	}
+	return c.EntriesRecordVersion
+}
+
+// slowOpenThreshold returns the configured SlowOpenThreshold, or the default one if not set.
+func (c *Config) slowOpenThreshold() time.Duration {
+	if c.SlowOpenThreshold == 0 {
+		return defaultSlowOpenThreshold
+	}
+	return c.SlowOpenThreshold
+}
+
+// diskSizeUpdateInterval returns the configured DiskSizeUpdateInterval, or the default one if not set.
+func (c *Config) diskSizeUpdateInterval() time.Duration {
+	if c.DiskSizeUpdateInterval == 0 {
+		return defaultDiskSizeUpdateInterval
+	}
+	return c.DiskSizeUpdateInterval
+}
@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg Config
+		err string
+	}{
+		"default segment size": {
+			cfg: Config{},
+		},
+		"custom segment size": {
+			cfg: Config{SegmentSize: 4 * minSegmentSize},
+		},
+		"interval sync mode": {
+			cfg: Config{SyncMode: SyncModeInterval, SyncInterval: time.Second},
+		},
+		"interval sync mode without interval": {
+			cfg: Config{SyncMode: SyncModeInterval},
+			err: "must be positive",
+		},
+		"unknown sync mode": {
+			cfg: Config{SyncMode: "sometimes"},
+			err: "invalid WAL sync mode",
+		},
+		"negative max size": {
+			cfg: Config{MaxSize: -1},
+			err: "must not be negative",
+		},
+		"negative disk size update interval": {
+			cfg: Config{DiskSizeUpdateInterval: -time.Second},
+			err: "must not be negative",
+		},
+		"mirror dir same as dir": {
+			cfg: Config{Dir: "/wal", MirrorDir: "/wal/"},
+			err: "must be different from the WAL dir",
+		},
+		"negative record buffer size": {
+			cfg: Config{RecordBufferSize: -1},
+			err: "must not be negative",
+		},
+		"segment size too small": {
+			cfg: Config{SegmentSize: walPageSize},
+			err: "must be at least",
+		},
+		"segment size not page aligned": {
+			cfg: Config{SegmentSize: minSegmentSize + 1},
+			err: "must be a multiple of",
+		},
+		"older entries record version": {
+			cfg: Config{EntriesRecordVersion: wal.WALRecordEntriesV1},
+		},
+		"unsupported entries record version": {
+			cfg: Config{EntriesRecordVersion: wal.CheckpointRecord},
+			err: "invalid WAL entries record version 3: must be 2 or 4",
+		},
+		"negative slow open threshold": {
+			cfg: Config{SlowOpenThreshold: -time.Second},
+			err: "invalid WAL slow open threshold -1s: must not be negative",
+		},
+		"negative stat cache TTL": {
+			cfg: Config{StatCacheTTL: -time.Second},
+			err: "invalid WAL stat cache TTL -1s: must not be negative",
+		},
+		"archive dir same as dir": {
+			cfg: Config{Dir: "/wal", ArchiveDir: "/wal/"},
+			err: `invalid WAL archive dir "/wal/": must be different from the WAL dir`,
+		},
+		"negative max segments": {
+			cfg: Config{MaxSegments: -1},
+			err: "invalid WAL max segments -1: must not be negative",
+		},
+		"negative max record age": {
+			cfg: Config{MaxRecordAge: -time.Second},
+			err: "invalid WAL max record age -1s: must not be negative",
+		},
+		"negative write retries": {
+			cfg: Config{WriteRetries: -1},
+			err: "invalid WAL write retries -1: must not be negative",
+		},
+		"negative write retry backoff": {
+			cfg: Config{WriteRetries: 1, WriteRetryBackoff: -time.Second},
+			err: "invalid WAL write retry backoff -1s: must not be negative",
+		},
+		"negative buffer size": {
+			cfg: Config{BufferSize: -1},
+			err: "invalid WAL buffer size -1: must not be negative",
+		},
+		"buffer size with per record sync": {
+			cfg: Config{BufferSize: 1024, SyncMode: SyncModePerRecord},
+			err: "must be zero with sync mode",
+		},
+		"segment name and number funcs": {
+			cfg: Config{SegmentNameFunc: defaultSegmentName, SegmentNumberFunc: defaultSegmentNumber},
+		},
+		"segment name func only": {
+			cfg: Config{SegmentNameFunc: defaultSegmentName},
+			err: "segment name and number functions must be set together",
+		},
+		"read only": {
+			cfg: Config{ReadOnly: true},
+		},
+		"read only with repair on open": {
+			cfg: Config{ReadOnly: true, RepairOnOpen: true},
+			err: "repairOnOpen can't be enabled on a read-only WAL",
+		},
+		"enabled with dir": {
+			cfg: Config{Enabled: true, Dir: "/wal"},
+		},
+		"enabled without dir": {
+			cfg: Config{Enabled: true},
+			err: "invalid WAL dir: must be set",
+		},
+		"disabled without dir": {
+			cfg: Config{Enabled: false},
+		},
+		"negative sync interval": {
+			cfg: Config{SyncInterval: -time.Second},
+			err: "invalid WAL sync interval -1s: must not be negative",
+		},
+		"negative segment size": {
+			cfg: Config{SegmentSize: -1},
+			err: "invalid WAL segment size -1: must not be negative",
+		},
+		"segment size within max size": {
+			cfg: Config{SegmentSize: minSegmentSize, MaxSize: 2 * minSegmentSize},
+		},
+		"segment size equal to max size": {
+			cfg: Config{SegmentSize: minSegmentSize, MaxSize: minSegmentSize},
+		},
+		"segment size exceeding max size": {
+			cfg: Config{SegmentSize: 2 * minSegmentSize, MaxSize: minSegmentSize},
+			err: "must not exceed the WAL max size",
+		},
+		"default segment size with small max size": {
+			cfg: Config{MaxSize: 1024},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
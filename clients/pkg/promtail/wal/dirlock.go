@@ -0,0 +1,46 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// ErrWALDirInUse is returned when opening a WAL on a directory another WAL of this process has open, which can happen
+// when different client and tenant names, or a custom Config.DirFunc, resolve to the same directory. Both WALs would
+// write segments with the same names otherwise. WALs opened with Config.ReadOnly don't claim their directory.
+var ErrWALDirInUse = errors.New("WAL dir is already in use")
+
+// openDirs holds the directories of the WALs open in this process, see ErrWALDirInUse.
+var openDirs = struct {
+	sync.Mutex
+	dirs map[string]struct{}
+}{dirs: map[string]struct{}{}}
+
+// dirKey returns the key dir is registered with in openDirs, so that different paths to the same directory collide.
+func dirKey(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
+
+// acquireDir claims dir for a WAL, returning ErrWALDirInUse if another WAL already has it.
+func acquireDir(dir string) error {
+	key := dirKey(dir)
+	openDirs.Lock()
+	defer openDirs.Unlock()
+	if _, ok := openDirs.dirs[key]; ok {
+		return fmt.Errorf("wal dir %q: %w", dir, ErrWALDirInUse)
+	}
+	openDirs.dirs[key] = struct{}{}
+	return nil
+}
+
+// releaseDir releases dir, claimed by acquireDir, once its WAL is closed.
+func releaseDir(dir string) {
+	openDirs.Lock()
+	defer openDirs.Unlock()
+	delete(openDirs.dirs, dirKey(dir))
+}
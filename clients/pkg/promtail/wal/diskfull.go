@@ -0,0 +1,85 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/go-kit/log/level"
+)
+
+// ErrDiskFull is returned by Log and the other write methods when the disk the WAL is written to has no more space
+// left. The returned error also wraps the underlying syscall.ENOSPC.
+var ErrDiskFull = errors.New("WAL disk is full")
+
+// diskFullError marks a write error caused by a full disk as ErrDiskFull, keeping the original error in the chain.
+type diskFullError struct {
+	err error
+}
+
+func (e diskFullError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrDiskFull, e.err)
+}
+
+func (e diskFullError) Unwrap() error {
+	return e.err
+}
+
+func (e diskFullError) Is(target error) bool {
+	return target == ErrDiskFull
+}
+
+// asDiskFull returns err marked as ErrDiskFull if it was caused by a full disk, and err unchanged otherwise.
+func asDiskFull(err error) error {
+	if err == nil || !errors.Is(err, syscall.ENOSPC) || errors.Is(err, ErrDiskFull) {
+		return err
+	}
+	return diskFullError{err: err}
+}
+
+// logFreeingSpace calls write, which must not hold mtx, retrying it as retryWrites does, and handles it still failing
+// with ErrDiskFull. If Config.MaxSize is set, so that the WAL is allowed to drop old data, the oldest segment is evicted
+// to free some space and write is retried once more.
+func (w *wrapper) logFreeingSpace(write func() error) error {
+	err := w.retryWrites(write)
+	if !errors.Is(err, ErrDiskFull) {
+		return err
+	}
+	w.metrics.diskFull.WithLabelValues(w.clientName, w.tenantID).Inc()
+	if w.cfg.MaxSize == 0 {
+		level.Error(w.log).Log("msg", "WAL disk is full", "err", err)
+		return err
+	}
+	evicted, evictErr := w.evictOldest()
+	if evictErr != nil {
+		level.Error(w.log).Log("msg", "WAL disk is full, and evicting the oldest segment failed", "err", err, "evictErr", evictErr)
+		return err
+	}
+	if evicted < 0 {
+		level.Error(w.log).Log("msg", "WAL disk is full, and there's no segment left to evict", "err", err)
+		return err
+	}
+	level.Warn(w.log).Log("msg", "WAL disk is full, evicted the oldest segment to retry the write", "segment", evicted)
+	return w.retryWrites(write)
+}
+
+// evictOldest deletes the oldest segment in the WAL, returning its number, or -1 if only the head segment is left.
+func (w *wrapper) evictOldest() (int, error) {
+	defer w.notifySegmentsDeleted()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return -1, err
+	}
+	if len(segments) < 2 {
+		return -1, nil
+	}
+	segment := segments[0]
+	if err := w.removeSegment(segment.name, segment.number); err != nil && !os.IsNotExist(err) {
+		return -1, fmt.Errorf("error evicting segment %d: %w", segment.number, err)
+	}
+	w.metrics.segmentsEvicted.WithLabelValues(w.clientName, w.tenantID).Inc()
+	return segment.number, w.dirChanged()
+}
@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fullDiskWL fails writes with ENOSPC as long as the given file exists.
+type fullDiskWL struct {
+	writeLog
+	path     string
+	attempts int
+}
+
+func (f *fullDiskWL) Log(recs ...[]byte) error {
+	f.attempts++
+	if _, err := os.Stat(f.path); err == nil {
+		return &os.PathError{Op: "write", Path: f.path, Err: syscall.ENOSPC}
+	}
+	return f.writeLog.Log(recs...)
+}
+
+func TestWAL_DiskFull(t *testing.T) {
+	newFullWAL := func(t *testing.T, maxSize int64) (WAL, *fullDiskWL, string) {
+		dir := t.TempDir()
+		w, err := New(Config{Enabled: true, Dir: dir, MaxSize: maxSize}, log.NewNopLogger(), prometheus.NewRegistry())
+		require.NoError(t, err)
+		requireLog(t, w, newTestRecord(1, "first"))
+		_, err = w.NextSegment()
+		require.NoError(t, err)
+		full := injectWL(w, func(wl writeLog) *fullDiskWL {
+			return &fullDiskWL{writeLog: wl, path: filepath.Join(dir, "00000000")}
+		})
+		return w, full, dir
+	}
+	diskFullCount := func(w WAL) float64 {
+		return testutil.ToFloat64(w.(*wrapper).metrics.diskFull.WithLabelValues("", ""))
+	}
+
+	t.Run("evicts the oldest segment and retries", func(t *testing.T) {
+		w, full, _ := newFullWAL(t, 1<<30)
+		defer w.Close()
+
+		requireLog(t, w, newTestRecord(1, "second"))
+		require.Equal(t, 2, full.attempts)
+		require.Equal(t, 1.0, diskFullCount(w))
+		segments, err := w.Segments()
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, segments)
+	})
+
+	t.Run("nothing left to evict", func(t *testing.T) {
+		w, full, dir := newFullWAL(t, 1<<30)
+		defer w.Close()
+		// the head segment is never evicted, so the disk stays full
+		require.NoError(t, w.DeleteSegment(0))
+		full.path = filepath.Join(dir, "00000001")
+
+		_, err := w.Log(newTestRecord(1, "second"))
+		require.ErrorIs(t, err, ErrDiskFull)
+		require.Equal(t, 1, full.attempts)
+		require.Equal(t, 1.0, diskFullCount(w))
+	})
+
+	t.Run("no eviction without max size", func(t *testing.T) {
+		w, full, _ := newFullWAL(t, 0)
+		defer w.Close()
+
+		_, err := w.Log(newTestRecord(1, "second"))
+		require.ErrorIs(t, err, ErrDiskFull)
+		require.ErrorIs(t, err, syscall.ENOSPC)
+		require.Equal(t, 1, full.attempts)
+		require.Equal(t, 1.0, diskFullCount(w))
+		segments, err := w.Segments()
+		require.NoError(t, err)
+		require.Equal(t, []int{0, 1}, segments)
+	})
+
+	t.Run("other errors aren't disk full", func(t *testing.T) {
+		require.NoError(t, asDiskFull(nil))
+		require.False(t, errors.Is(asDiskFull(os.ErrPermission), ErrDiskFull))
+	})
+}
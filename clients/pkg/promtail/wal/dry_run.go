@@ -0,0 +1,33 @@
+package wal
+
+// dryRunLog is the writeLog used when Config.DryRun is enabled. Records are fully encoded by the wrapper, but never
+// written, so that no segments are created.
+type dryRunLog struct {
+	dir     string
+	segment int
+}
+
+func (d *dryRunLog) Log(...[]byte) error {
+	return nil
+}
+
+func (d *dryRunLog) Sync() error {
+	return nil
+}
+
+func (d *dryRunLog) Close() error {
+	return nil
+}
+
+func (d *dryRunLog) Dir() string {
+	return d.dir
+}
+
+func (d *dryRunLog) NextSegmentSync() (int, error) {
+	d.segment++
+	return d.segment, nil
+}
+
+func (d *dryRunLog) Repair(error) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package wal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_DryRun(t *testing.T) {
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:     true,
+		Dir:         t.TempDir(),
+		SegmentSize: minSegmentSize,
+		DryRun:      true,
+	}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+
+	written, err := w.Log(newTestRecord(1, "line"))
+	require.NoError(t, err)
+	require.NotZero(t, written)
+	_, err = w.Log(newTestRecord(2, strings.Repeat("a", minSegmentSize)))
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+
+	count, err := w.CountSegments()
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	metrics := w.(*wrapper).metrics
+	require.Equal(t, float64(written), testutil.ToFloat64(metrics.dryRunBytes.WithLabelValues("client", "tenant")))
+	require.Zero(t, testutil.ToFloat64(metrics.bytesLogged.WithLabelValues("client", "tenant")))
+}
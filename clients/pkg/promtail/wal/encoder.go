@@ -0,0 +1,107 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// Encoder converts records to and from the bytes stored in the WAL. Each buffer returned by Encode is written as a
+// separate WAL record, and passed back to Decode when reading the WAL.
+type Encoder interface {
+	Encode(rec *wal.Record) ([][]byte, error)
+	Decode(b []byte) (*wal.Record, error)
+}
+
+// DefaultEncoder encodes records as the WAL does when no Encoder is configured, with series and entries stored as
+// separate WAL records, and series before entries.
+type DefaultEncoder struct{}
+
+func (DefaultEncoder) Encode(rec *wal.Record) ([][]byte, error) {
+	return EncodeRecord(rec)
+}
+
+func (DefaultEncoder) Decode(b []byte) (*wal.Record, error) {
+	return DecodeRecord([][]byte{b})
+}
+
+// EncodeRecord encodes rec as the WAL does when no Encoder is configured, returning the series and entries buffers in
+// that order, omitting empty ones. Checksums aren't added. It allows measuring the encoded size of records without a
+// WAL.
+func EncodeRecord(rec *wal.Record) ([][]byte, error) {
+	if rec == nil {
+		return nil, nil
+	}
+	var bufs [][]byte
+	if len(rec.Series) > 0 {
+		bufs = append(bufs, rec.EncodeSeries(nil))
+	}
+	if len(rec.RefEntries) > 0 {
+		bufs = append(bufs, encodeEntries(rec, wal.CurrentEntriesRec)(nil))
+	}
+	return bufs, nil
+}
+
+// DecodeRecord decodes buffers encoded by EncodeRecord back into a single record.
+func DecodeRecord(bufs [][]byte) (*wal.Record, error) {
+	rec := &wal.Record{}
+	for _, b := range bufs {
+		// decoding an entries record clears the series decoded so far
+		series := rec.Series
+		if err := wal.DecodeRecord(b, rec); err != nil {
+			return nil, fmt.Errorf("error decoding wal record: %w", err)
+		}
+		if len(rec.Series) == 0 {
+			rec.Series = series
+		}
+	}
+	return rec, nil
+}
+
+// encodeError wraps errors returned by the configured Encoder, telling them apart from errors writing to the WAL.
+type encodeError struct {
+	err error
+}
+
+func (e encodeError) Error() string { return e.err.Error() }
+func (e encodeError) Unwrap() error { return e.err }
+
+// encodeCustom encodes record with the configured Encoder, adding checksums if enabled.
+func (w *wrapper) encodeCustom(record *wal.Record) ([][]byte, error) {
+	bufs, err := w.cfg.Encoder.Encode(record)
+	if err != nil {
+		return nil, encodeError{err}
+	}
+	if w.cfg.RecordChecksums {
+		for i, buf := range bufs {
+			encoded := buf
+			bufs[i] = encodeRecord(nil, true, func(b []byte) []byte { return append(b, encoded...) })
+		}
+	}
+	return bufs, nil
+}
+
+// logEncoded logs record as encoded by the configured Encoder, in a single WAL operation.
+func (w *wrapper) logEncoded(record *wal.Record) (int, error) {
+	recs, err := w.encodeCustom(record)
+	if err != nil {
+		return 0, err
+	}
+	for _, rec := range recs {
+		if err := w.checkRecordSize(rec); err != nil {
+			return 0, err
+		}
+	}
+	if len(recs) == 0 {
+		return 0, nil
+	}
+	if err := w.logRecords(recs...); err != nil {
+		return 0, err
+	}
+	var written int
+	for _, rec := range recs {
+		w.recordLogged(len(rec))
+		written += len(rec)
+	}
+	return written, nil
+}
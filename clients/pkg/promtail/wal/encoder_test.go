@@ -0,0 +1,192 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// linesEncoder is a trivial Encoder storing only the entry lines of a record, as plain text.
+type linesEncoder struct{}
+
+func (linesEncoder) Encode(rec *wal.Record) ([][]byte, error) {
+	var lines []string
+	for _, entries := range rec.RefEntries {
+		for _, e := range entries.Entries {
+			lines = append(lines, e.Line)
+		}
+	}
+	return [][]byte{[]byte("lines:" + strings.Join(lines, "\n"))}, nil
+}
+
+func (linesEncoder) Decode(b []byte) (*wal.Record, error) {
+	entries := wal.RefEntries{}
+	for _, line := range strings.Split(strings.TrimPrefix(string(b), "lines:"), "\n") {
+		entries.Entries = append(entries.Entries, logproto.Entry{Line: line})
+	}
+	return &wal.Record{RefEntries: []wal.RefEntries{entries}}, nil
+}
+
+func TestWAL_CustomEncoder(t *testing.T) {
+	for name, checksums := range map[string]bool{"without checksums": false, "with checksums": true} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := New(Config{Enabled: true, Dir: dir, Encoder: linesEncoder{}, RecordChecksums: checksums}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			requireLog(t, w, newTestRecord(1, "first", "second"))
+			require.NoError(t, w.LogBatch([]*wal.Record{newTestRecord(2, "third")}))
+			require.NoError(t, w.Close())
+
+			content, err := os.ReadFile(filepath.Join(dir, "00000000"))
+			require.NoError(t, err)
+			require.Contains(t, string(content), "lines:first\nsecond")
+
+			lines, err := replayLines(w)
+			require.NoError(t, err)
+			require.Equal(t, []string{"first", "second", "third"}, lines)
+
+			r, err := w.(Extended).NewReader()
+			require.NoError(t, err)
+			defer r.Close()
+			require.True(t, r.Next())
+			require.Equal(t, "first", r.Record().RefEntries[0].Entries[0].Line)
+		})
+	}
+}
+
+func TestWAL_DefaultEncoderMatchesBuiltinEncoding(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, Encoder: DefaultEncoder{}}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "first"))
+	require.NoError(t, w.Close())
+
+	// a WAL without a custom encoder reads back what DefaultEncoder wrote
+	w, err = New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first"}, lines)
+}
+
+func TestEncodeRecord(t *testing.T) {
+	for name, tc := range map[string]struct {
+		rec  *wal.Record
+		bufs int
+	}{
+		"series and entries": {rec: newTestRecord(1, "first", "second"), bufs: 2},
+		"series only":        {rec: &wal.Record{Series: newTestRecord(2).Series}, bufs: 1},
+		"entries only":       {rec: &wal.Record{RefEntries: newTestRecord(3, "line").RefEntries}, bufs: 1},
+		"with user ID":       {rec: &wal.Record{UserID: "tenant", Series: newTestRecord(4).Series}, bufs: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			bufs, err := EncodeRecord(tc.rec)
+			require.NoError(t, err)
+			require.Len(t, bufs, tc.bufs)
+
+			decoded, err := DecodeRecord(bufs)
+			require.NoError(t, err)
+			require.Equal(t, tc.rec, decoded)
+		})
+	}
+
+	bufs, err := EncodeRecord(&wal.Record{})
+	require.NoError(t, err)
+	require.Empty(t, bufs)
+
+	_, err = DecodeRecord([][]byte{{0xff}})
+	require.Error(t, err)
+}
+
+func TestEncodeRecord_MatchesLog(t *testing.T) {
+	rec := newTestRecord(1, "line")
+	bufs, err := EncodeRecord(rec)
+	require.NoError(t, err)
+
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	written, err := w.Log(rec)
+	require.NoError(t, err)
+	require.Equal(t, len(bufs[0])+len(bufs[1]), written)
+}
+
+func TestWAL_EntriesRecordVersion(t *testing.T) {
+	for name, tc := range map[string]struct {
+		version wal.RecordType
+		want    wal.RecordType
+	}{
+		"default":  {want: wal.CurrentEntriesRec},
+		"older v1": {version: wal.WALRecordEntriesV1, want: wal.WALRecordEntriesV1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := New(Config{Enabled: true, Dir: dir, EntriesRecordVersion: tc.version}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			requireLog(t, w, newTestRecord(1, "first", "second"))
+			require.NoError(t, w.Close())
+
+			segment, reader, err := openSegment(filepath.Join(dir, "00000000"))
+			require.NoError(t, err)
+			defer segment.Close()
+			var versions []wal.RecordType
+			for reader.Next() {
+				if isVersion, _ := checkVersionRecord(reader.Record()); !isVersion {
+					versions = append(versions, wal.RecordType(reader.Record()[0]))
+				}
+			}
+			require.NoError(t, reader.Err())
+			require.Equal(t, []wal.RecordType{wal.WALRecordSeries, tc.want}, versions)
+
+			lines, err := replayLines(w)
+			require.NoError(t, err)
+			require.Equal(t, []string{"first", "second"}, lines)
+		})
+	}
+}
+
+func TestWAL_DeterministicEncoding(t *testing.T) {
+	unsorted := func() *wal.Record {
+		rec := newTestRecord(1, "line")
+		rec.Series[0].Labels = labels.Labels{{Name: "zone", Value: "b"}, {Name: "app", Value: "a"}}
+		return rec
+	}
+	sorted := func() *wal.Record {
+		rec := newTestRecord(1, "line")
+		rec.Series[0].Labels = labels.FromStrings("zone", "b", "app", "a")
+		return rec
+	}
+	segmentBytes := func(t *testing.T, deterministic bool, recs ...*wal.Record) []byte {
+		dir := t.TempDir()
+		w, err := New(Config{Enabled: true, Dir: dir, DeterministicEncoding: deterministic}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		for _, rec := range recs {
+			requireLog(t, w, rec)
+		}
+		require.NoError(t, w.LogBatch(recs))
+		require.NoError(t, w.Close())
+		b, err := os.ReadFile(filepath.Join(dir, "00000000"))
+		require.NoError(t, err)
+		return b
+	}
+
+	rec := unsorted()
+	first := segmentBytes(t, true, rec, newTestRecord(2, "other"))
+	require.Equal(t, first, segmentBytes(t, true, unsorted(), newTestRecord(2, "other")))
+	require.Equal(t, first, segmentBytes(t, true, sorted(), newTestRecord(2, "other")))
+	// the logged record is left untouched
+	require.Equal(t, unsorted(), rec)
+
+	// without it, labels are encoded in the order they're found
+	require.NotEqual(t, first, segmentBytes(t, false, unsorted(), newTestRecord(2, "other")))
+	require.Equal(t, first, segmentBytes(t, false, sorted(), newTestRecord(2, "other")))
+}
@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// exportMagic starts every stream written by Export, followed by the exportVersion byte.
+var exportMagic = []byte("promtail-wal-export")
+
+// exportVersion is the version of the stream format written by Export.
+const exportVersion = 1
+
+// Export writes all records in the WAL to out as a self-describing stream, that Import reads back, returning the number
+// of bytes written. The stream starts with a magic header and format version, followed by one frame per record. Each
+// frame holds the series and entries of the record, encoded as EncodeRecord does, each prefixed by its uvarint length,
+// with a zero length for a missing part. The stream doesn't depend on how the WAL is configured, such as with
+// checksums or a custom Encoder, so that it can be imported into any WAL.
+//
+// Being meant for backups, Export reads every segment, including those before the checkpoint, without any of the
+// filters Replay applies, such as Config.MaxRecordAge, and without updating the replay metrics. It stops at the first
+// corrupted record, returning an error, with the records before it already written to out.
+func (w *wrapper) Export(out io.Writer) (int64, error) {
+	var written int64
+	write := func(b []byte) error {
+		n, err := out.Write(b)
+		written += int64(n)
+		return err
+	}
+	if err := write(append(append([]byte{}, exportMagic...), exportVersion)); err != nil {
+		return written, fmt.Errorf("failed to write WAL export header: %w", err)
+	}
+	var frame []byte
+	err := w.readAll(func(rec *wal.Record) error {
+		frame = frame[:0]
+		var series, entries []byte
+		if len(rec.Series) > 0 {
+			series = rec.EncodeSeries(nil)
+		}
+		if len(rec.RefEntries) > 0 {
+			entries = encodeEntries(rec, wal.CurrentEntriesRec)(nil)
+		}
+		frame = binary.AppendUvarint(frame, uint64(len(series)))
+		frame = append(frame, series...)
+		frame = binary.AppendUvarint(frame, uint64(len(entries)))
+		frame = append(frame, entries...)
+		if err := write(frame); err != nil {
+			return fmt.Errorf("failed to write WAL export record: %w", err)
+		}
+		return nil
+	})
+	return written, err
+}
+
+// readAll reads all records of all segments, in order, passing each to handler, which must not retain it. It stops at the
+// first corrupted record, or error returned by handler.
+func (w *wrapper) readAll(handler func(*wal.Record) error) error {
+	return w.readSegments(handler, false)
+}
+
+// scanAll is readAll, but skips the rest of corrupted segments, continuing with the next one.
+func (w *wrapper) scanAll(handler func(*wal.Record) error) error {
+	return w.readSegments(handler, true)
+}
+
+func (w *wrapper) readSegments(handler func(*wal.Record) error, skipCorrupted bool) error {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	rec := w.pool.GetRecord()
+	defer w.pool.PutRecord(rec)
+	for _, segment := range segments {
+		corruption, err := w.replaySegment(segment.number, rec, handler)
+		if err != nil {
+			return err
+		}
+		if corruption != nil && !skipCorrupted {
+			return fmt.Errorf("corrupted WAL segment %d: %w", segment.number, corruption)
+		}
+	}
+	return nil
+}
+
+// Import logs all records of a stream written by Export into the WAL, as Log would, in the order they were exported.
+// Records imported before an error is found in the stream are kept in the WAL.
+func (w *wrapper) Import(in io.Reader) error {
+	r := bufio.NewReader(in)
+	header := make([]byte, len(exportMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read WAL export header: %w", err)
+	}
+	if !bytes.Equal(header[:len(exportMagic)], exportMagic) {
+		return errors.New("invalid WAL export: missing header")
+	}
+	if version := header[len(exportMagic)]; version != exportVersion {
+		return fmt.Errorf("unsupported WAL export version %d", version)
+	}
+	for records := 0; ; records++ {
+		series, err := readExportPart(r, w.cfg.segmentSize())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read WAL export record %d: %w", records, err)
+		}
+		entries, err := readExportPart(r, w.cfg.segmentSize())
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("failed to read WAL export record %d: %w", records, err)
+		}
+		var bufs [][]byte
+		for _, b := range [][]byte{series, entries} {
+			if len(b) > 0 {
+				bufs = append(bufs, b)
+			}
+		}
+		rec, err := DecodeRecord(bufs)
+		if err != nil {
+			return fmt.Errorf("failed to decode WAL export record %d: %w", records, err)
+		}
+		if _, err := w.Log(rec); err != nil {
+			return fmt.Errorf("failed to import WAL export record %d: %w", records, err)
+		}
+	}
+}
+
+// readExportPart reads a length-prefixed part of an export frame, refusing parts longer than limit, which couldn't be
+// logged anyway. It returns io.EOF only if the stream ends before the length.
+func readExportPart(r *bufio.Reader, limit int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(limit) {
+		return nil, fmt.Errorf("%w: exported record part of %d bytes exceeds the segment size of %d bytes", ErrRecordTooLarge, n, limit)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,137 @@
+package wal
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestWAL_ExportImport(t *testing.T) {
+	src, err := New(Config{Enabled: true, Dir: t.TempDir(), RecordChecksums: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, src, newTestRecord(1, "first", "second"))
+	_, err = src.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, src, &wal.Record{Series: newTestRecord(2).Series})
+	requireLog(t, src, newTestRecord(3, "third"))
+	require.NoError(t, src.Close())
+
+	var buf bytes.Buffer
+	written, err := src.(Extended).Export(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), written)
+
+	// the export doesn't depend on the source WAL using checksums
+	dst, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, dst.(Extended).Import(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, dst.Close())
+
+	var want, got []*wal.Record
+	collect := func(records *[]*wal.Record) func(*wal.Record) error {
+		return func(rec *wal.Record) error {
+			bufs, err := EncodeRecord(rec)
+			require.NoError(t, err)
+			decoded, err := DecodeRecord(bufs)
+			require.NoError(t, err)
+			*records = append(*records, decoded)
+			return nil
+		}
+	}
+	require.NoError(t, src.Replay(collect(&want)))
+	require.NoError(t, dst.Replay(collect(&got)))
+	// replays yield series and entries as separate records
+	require.Len(t, got, 5)
+	require.Equal(t, want, got)
+}
+
+func TestWAL_ExportIgnoresReplayFilters(t *testing.T) {
+	dir := t.TempDir()
+	src, err := New(Config{Enabled: true, Dir: dir, MaxRecordAge: time.Hour}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	// entries of newTestRecord are timestamped around the epoch, so they're all stale
+	requireLog(t, src, newTestRecord(1, "first"))
+	_, err = src.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, src, newTestRecord(2, "second"))
+	require.NoError(t, src.(Extended).Checkpoint(1))
+
+	var buf bytes.Buffer
+	_, err = src.(Extended).Export(&buf)
+	require.NoError(t, err)
+	require.Zero(t, testutil.ToFloat64(src.(*wrapper).metrics.replayedRecords.WithLabelValues("", "")))
+
+	dst, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.(Extended).Import(&buf))
+	lines, err := replayLines(dst)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, lines)
+}
+
+func TestWAL_ExportStopsAtCorruption(t *testing.T) {
+	w := newCorruptedWAL(t, t.TempDir(), true)
+
+	var buf bytes.Buffer
+	_, err := w.(Extended).Export(&buf)
+	require.ErrorContains(t, err, "corrupted WAL segment 0")
+
+	dst, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.(Extended).Import(&buf))
+	lines, err := replayLines(dst)
+	require.NoError(t, err)
+	// the segment after the corrupted one isn't exported
+	require.Equal(t, []string{"first"}, lines)
+}
+
+func TestWAL_ImportInvalidStream(t *testing.T) {
+	src, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, src, newTestRecord(1, "first"))
+	requireLog(t, src, newTestRecord(1, "second"))
+	require.NoError(t, src.Close())
+	var buf bytes.Buffer
+	_, err = src.(Extended).Export(&buf)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		stream []byte
+		err    string
+	}{
+		"empty": {
+			err: "failed to read WAL export header",
+		},
+		"not an export": {
+			stream: []byte(strings.Repeat("x", 64)),
+			err:    "missing header",
+		},
+		"unsupported version": {
+			stream: append(append([]byte{}, exportMagic...), exportVersion+1),
+			err:    "unsupported WAL export version 2",
+		},
+		"truncated record": {
+			stream: buf.Bytes()[:buf.Len()-1],
+			err:    io.ErrUnexpectedEOF.Error(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			defer dst.Close()
+			err = dst.(Extended).Import(bytes.NewReader(tc.stream))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
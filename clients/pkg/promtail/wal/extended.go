@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"context"
+	"io"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// Extended is implemented by the disk-backed WALs returned by New and NewWAL when the WAL is enabled, and exposes the
+// operations beyond WAL that are specific to them. The no-op and in-memory WALs don't implement it, so callers
+// needing these operations should get them with a type assertion:
+//
+//	if ext, ok := w.(wal.Extended); ok {
+//		_, err = ext.Rotate()
+//	}
+type Extended interface {
+	WAL
+
+	// LogContext logs a record as Log does, unless ctx is already done.
+	LogContext(ctx context.Context, record *wal.Record) (int, error)
+	// LogAll logs records one after the other, reusing the same encoding buffers.
+	LogAll(records []*wal.Record) error
+	// LogTracked logs a record as Log does, returning the segment it was written to.
+	LogTracked(record *wal.Record) (int, error)
+	// LogToSegment logs a record into the given segment, creating it and the ones before it as needed.
+	LogToSegment(segment int, record *wal.Record) error
+	// EstimateSize returns the number of bytes Log would write for record, without writing it.
+	EstimateSize(record *wal.Record) (int, error)
+	// Flush writes buffered records to the underlying WAL, when Config.BufferSize is set.
+	Flush() error
+	// GetRecord and PutRecord get and return records from the WAL pool.
+	GetRecord() *wal.Record
+	PutRecord(rec *wal.Record)
+
+	// ReplayWithProgress replays the WAL as Replay does, reporting the progress after each segment.
+	ReplayWithProgress(handler func(*wal.Record) error, progress func(segment int, recordsSoFar int)) error
+	// Drain replays the WAL as Replay does, removing each segment once handled.
+	Drain(handler func(*wal.Record) error) error
+	// NewReader creates a RecordReader over the segments currently in the WAL.
+	NewReader() (*RecordReader, error)
+	// Watch streams records as they're logged, until ctx is done.
+	Watch(ctx context.Context) (<-chan *wal.Record, error)
+	// Checkpoint records that the entries of the segments before upToSegment were delivered.
+	Checkpoint(upToSegment int) error
+
+	// Rotate seals the current segment, syncing it, and rotates to a new one.
+	Rotate() (int, error)
+	// StartSegment returns the segment the WAL started writing to when created.
+	StartSegment() int
+	// IsFull returns true if the WAL has reached Config.MaxSize.
+	IsFull() bool
+	// Pressure returns the ratio of the WAL size to Config.MaxSize.
+	Pressure() float64
+	// Compact rewrites the sealed segments into as few full-size segments as possible.
+	Compact() error
+	// Verify reads every record in the WAL, reporting which segments are corrupted.
+	Verify() (VerifyResult, error)
+	// Repair trims the first corrupted segment up to its last valid record.
+	Repair() error
+	// Reset reopens the WAL after being closed or deleted.
+	Reset() error
+
+	// Export writes all records of the WAL to out, and Import logs the records of such a stream.
+	Export(out io.Writer) (int64, error)
+	Import(in io.Reader) error
+	// RestoreArchive restores the archive of a segment, written when Config.ArchiveDir is set.
+	RestoreArchive(segmentNum int) error
+	// WriteSegmentMeta and ReadSegmentMeta persist and read back data stored alongside a segment.
+	WriteSegmentMeta(segmentNum int, data []byte) error
+	ReadSegmentMeta(segmentNum int) ([]byte, error)
+	// Unwrap returns the wlog.WL currently written to.
+	Unwrap() *wlog.WL
+}
+
+var _ Extended = (*wrapper)(nil)
@@ -0,0 +1,22 @@
+package wal
+
+import (
+	"io/fs"
+	"os"
+)
+
+// FS is the filesystem the WAL lists, inspects and removes segments through. Segments are always written by wlog to
+// the OS filesystem, so an FS must expose the same files under the WAL directory, for example by wrapping OSFS to
+// instrument operations or to inject failures in tests.
+type FS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Remove(name string) error
+}
+
+// OSFS is the FS backed by the OS filesystem, used unless Config.FS is set.
+type OSFS struct{}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (OSFS) Remove(name string) error                   { return os.Remove(name) }
@@ -0,0 +1,132 @@
+package wal
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// memFS is an in-memory FS holding the files of a single directory, which can be made to fail operations on given
+// file names.
+type memFS struct {
+	mtx   sync.Mutex
+	dir   string
+	files fstest.MapFS
+	errs  map[string]error
+}
+
+func newMemFS(dir string, sizes map[string]int) *memFS {
+	m := &memFS{dir: dir, files: fstest.MapFS{}, errs: map[string]error{}}
+	for name, size := range sizes {
+		m.files[name] = &fstest.MapFile{Data: make([]byte, size)}
+	}
+	return m
+}
+
+// name returns the name of path relative to the memFS directory, or the error to fail with. The directory itself is
+// named ".".
+func (m *memFS) name(path string) (string, error) {
+	name, err := filepath.Rel(m.dir, path)
+	if err != nil {
+		return "", err
+	}
+	if err := m.errs[name]; err != nil {
+		return "", &fs.PathError{Op: "memfs", Path: path, Err: err}
+	}
+	return name, nil
+}
+
+func (m *memFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	name, err := m.name(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.files.ReadDir(name)
+}
+
+func (m *memFS) Stat(path string) (fs.FileInfo, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	name, err := m.name(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.files.Stat(name)
+}
+
+func (m *memFS) Remove(path string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	name, err := m.name(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func TestWAL_FS(t *testing.T) {
+	dir := t.TempDir()
+	// the WAL still writes its segments to dir, but only the in-memory files are seen when scanning
+	mfs := newMemFS(dir, map[string]int{"00000000": 10, "00000003": 5, "00000005": 7, "notes": 100})
+	w, err := New(Config{Enabled: true, Dir: dir, FS: mfs}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(22), size)
+	count, err := w.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	t.Run("missing file", func(t *testing.T) {
+		require.ErrorContains(t, w.DeleteSegment(1), "delete segment 1: segment not found")
+	})
+
+	t.Run("permission error on remove", func(t *testing.T) {
+		mfs.errs["00000003"] = fs.ErrPermission
+		defer delete(mfs.errs, "00000003")
+		err := w.DeleteSegment(3)
+		require.ErrorIs(t, err, fs.ErrPermission)
+		var segmentErr *SegmentError
+		require.ErrorAs(t, err, &segmentErr)
+		require.Equal(t, 3, segmentErr.SegmentNum)
+		require.Equal(t, "delete", segmentErr.Op)
+
+		mfs.errs["00000000"] = fs.ErrPermission
+		defer delete(mfs.errs, "00000000")
+		err = w.Truncate(4)
+		require.ErrorIs(t, err, fs.ErrPermission)
+		require.ErrorAs(t, err, &segmentErr)
+		require.Equal(t, 0, segmentErr.SegmentNum)
+		require.Equal(t, "truncate", segmentErr.Op)
+		count, err := w.CountSegments()
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+	})
+
+	t.Run("permission error on scan", func(t *testing.T) {
+		mfs.errs["."] = fs.ErrPermission
+		defer delete(mfs.errs, ".")
+		_, err := w.Size()
+		require.ErrorIs(t, err, fs.ErrPermission)
+		_, err = w.CountSegments()
+		require.ErrorIs(t, err, fs.ErrPermission)
+	})
+
+	require.NoError(t, w.DeleteSegment(0))
+	size, err = w.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), size)
+}
@@ -0,0 +1,45 @@
+package wal
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+)
+
+// fsyncDir fsyncs dir, making the creation and removal of files in it durable. Directories can't be synced on Windows,
+// where it's a no-op.
+func fsyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := fileutil.OpenDir(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// withDirSync makes the WAL sync its directory with sync instead of fsyncing it.
+func withDirSync(sync func(dir string) error) walOption {
+	return func(w *wrapper) {
+		w.syncDir = sync
+	}
+}
+
+// dirChanged syncs the WAL directory after segments were created or removed in it, if Config.FsyncDir is set. Must be
+// called with mtx held.
+func (w *wrapper) dirChanged() error {
+	w.invalidateStatCache()
+	if !w.cfg.FsyncDir {
+		return nil
+	}
+	if err := w.syncDir(w.wal.Dir()); err != nil {
+		return fmt.Errorf("failed to sync WAL directory: %w", err)
+	}
+	return nil
+}
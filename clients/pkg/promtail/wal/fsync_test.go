@@ -0,0 +1,59 @@
+package wal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_FsyncDir(t *testing.T) {
+	var synced []string
+	var syncErr error
+	fakeSync := withDirSync(func(dir string) error {
+		synced = append(synced, dir)
+		return syncErr
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		synced = nil
+		w, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: t.TempDir(), FsyncDir: true}, "", "", fakeSync)
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.NextSegment()
+		require.NoError(t, err)
+		require.Equal(t, []string{w.Dir()}, synced)
+		require.NoError(t, w.DeleteSegment(0))
+		require.Equal(t, []string{w.Dir(), w.Dir()}, synced)
+		// nothing is removed, so there's nothing to sync
+		require.NoError(t, w.Truncate(0))
+		require.Len(t, synced, 2)
+
+		syncErr = errors.New("sync failed")
+		defer func() { syncErr = nil }()
+		_, err = w.NextSegment()
+		require.ErrorIs(t, err, syncErr)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		synced = nil
+		w, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: t.TempDir()}, "", "", fakeSync)
+		require.NoError(t, err)
+		defer w.Close()
+		_, err = w.NextSegment()
+		require.NoError(t, err)
+		require.NoError(t, w.DeleteSegment(0))
+		require.Empty(t, synced)
+	})
+
+	t.Run("real directory", func(t *testing.T) {
+		require.NoError(t, fsyncDir(t.TempDir()))
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), FsyncDir: true}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		_, err = w.NextSegment()
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// newSegmentedWAL returns a WAL in dir with one record logged to each of the given segments, holding the given lines,
+// creating empty segments for the numbers not listed.
+func newSegmentedWAL(t *testing.T, dir string, layout map[int][]string) WAL {
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { w.Close() })
+
+	var last int
+	for segment := range layout {
+		if segment > last {
+			last = segment
+		}
+	}
+	for segment := 0; segment <= last; segment++ {
+		if lines, ok := layout[segment]; ok {
+			require.NoError(t, w.(Extended).LogToSegment(segment, newTestRecord(uint64(segment+1), lines...)))
+		}
+	}
+	return w
+}
+
+func TestWAL_LogToSegment(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegmentedWAL(t, dir, map[int][]string{0: {"a"}, 2: {"b", "c"}, 5: {"d"}})
+	ww := w.(Extended)
+
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5}, segmentNumbers(t, dir))
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	// the series and entries of each record
+	require.Equal(t, map[int]int{0: 2, 1: 0, 2: 2, 3: 0, 4: 0, 5: 2}, stats.SegmentRecords)
+
+	// the current segment can still be written to
+	require.NoError(t, ww.LogToSegment(5, newTestRecord(7, "e")))
+	err = ww.LogToSegment(2, newTestRecord(8, "late"))
+	var segmentErr *SegmentError
+	require.ErrorAs(t, err, &segmentErr)
+	require.EqualError(t, err, "log to segment 2: segment is before the current one")
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, lines)
+}
+
+func TestWAL_LogToSegmentFixture(t *testing.T) {
+	// truncating a layout with gaps keeps exactly the records of the segments left
+	w := newSegmentedWAL(t, t.TempDir(), map[int][]string{0: {"first"}, 1: {"second"}, 3: {"third"}, 4: {"fourth"}})
+	require.NoError(t, w.Truncate(3))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"third", "fourth"}, lines)
+}
@@ -0,0 +1,117 @@
+package wal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/dskit/multierror"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// Manager routes records to a WAL per tenant of a single client. Each tenant WAL is created the first time a record
+// is logged for it, in the directory resolved from the shared configuration, and reused afterwards.
+type Manager struct {
+	log        log.Logger
+	registerer prometheus.Registerer
+	cfg        Config
+	clientName string
+
+	// syncer syncs all tenant WALs when running in SyncModeInterval, instead of a routine per tenant.
+	syncer *Syncer
+
+	mtx    sync.RWMutex
+	wals   map[string]WAL
+	closed bool
+}
+
+// NewManager creates a new Manager for the given client. No WAL is created until records are logged. When running in
+// SyncModeInterval, all tenant WALs are synced by a single Syncer, stopped by CloseAll.
+func NewManager(log log.Logger, registerer prometheus.Registerer, cfg Config, clientName string) *Manager {
+	m := &Manager{
+		log:        log,
+		registerer: registerer,
+		cfg:        cfg,
+		clientName: clientName,
+		wals:       map[string]WAL{},
+	}
+	if cfg.Enabled && cfg.SyncMode == SyncModeInterval && cfg.SyncInterval > 0 {
+		m.syncer = NewSyncer(log, cfg.SyncInterval)
+	}
+	return m
+}
+
+// Log writes record to the WAL of tenantID, creating it if needed.
+func (m *Manager) Log(tenantID string, record *wal.Record) error {
+	w, err := m.get(tenantID)
+	if err != nil {
+		return err
+	}
+	_, err = w.Log(record)
+	return err
+}
+
+// get returns the WAL of tenantID, creating it on first use.
+func (m *Manager) get(tenantID string) (WAL, error) {
+	m.mtx.RLock()
+	w, ok := m.wals[tenantID]
+	closed := m.closed
+	m.mtx.RUnlock()
+	if ok {
+		return w, nil
+	}
+	if closed {
+		return nil, fmt.Errorf("wal manager is closed")
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	// check again, since another routine might have created it, or closed the manager, while waiting for the lock
+	if m.closed {
+		return nil, fmt.Errorf("wal manager is closed")
+	}
+	if w, ok := m.wals[tenantID]; ok {
+		return w, nil
+	}
+	w, err := newWAL(m.log, m.registerer, m.cfg, m.clientName, tenantID, withSyncer(m.syncer))
+	if err != nil {
+		return nil, fmt.Errorf("error creating wal for tenant %s: %w", tenantID, err)
+	}
+	m.wals[tenantID] = w
+	return w, nil
+}
+
+// Tenants returns the tenants with a WAL created, sorted.
+func (m *Manager) Tenants() []string {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	tenants := make([]string, 0, len(m.wals))
+	for tenant := range m.wals {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// CloseAll flushes and closes the WALs of every tenant. After that, logging to the manager fails.
+func (m *Manager) CloseAll() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.closed = true
+	errs := multierror.New()
+	for tenant, w := range m.wals {
+		if err := w.Sync(); err != nil {
+			errs.Add(fmt.Errorf("error syncing wal for tenant %s: %w", tenant, err))
+		}
+		if err := w.Close(); err != nil {
+			errs.Add(fmt.Errorf("error closing wal for tenant %s: %w", tenant, err))
+		}
+	}
+	if m.syncer != nil {
+		m.syncer.Stop()
+	}
+	return errs.Err()
+}
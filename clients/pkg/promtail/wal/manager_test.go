@@ -0,0 +1,44 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: dir}, "client")
+	require.Empty(t, m.Tenants())
+
+	require.NoError(t, m.Log("tenant-b", newTestRecord(1, "b1")))
+	require.Equal(t, []string{"tenant-b"}, m.Tenants())
+	first := m.wals["tenant-b"]
+
+	require.NoError(t, m.Log("tenant-a", newTestRecord(1, "a1")))
+	require.NoError(t, m.Log("tenant-b", newTestRecord(1, "b2")))
+	require.Equal(t, []string{"tenant-a", "tenant-b"}, m.Tenants())
+	require.Same(t, first, m.wals["tenant-b"], "tenant wal should be reused")
+
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		_, err := os.Stat(filepath.Join(dir, "client", tenant))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, m.CloseAll())
+	require.Error(t, m.Log("tenant-a", newTestRecord(1, "a2")))
+	require.Error(t, m.Log("tenant-c", newTestRecord(1, "c1")))
+
+	for tenant, expected := range map[string][]string{
+		"tenant-a": {"a1"},
+		"tenant-b": {"b1", "b2"},
+	} {
+		lines, err := replayLines(m.wals[tenant])
+		require.NoError(t, err)
+		require.Equal(t, expected, lines, tenant)
+	}
+}
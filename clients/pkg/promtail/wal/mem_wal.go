@@ -0,0 +1,274 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// memWALDir is the synthetic directory reported by in-memory WALs.
+const memWALDir = "memory://wal"
+
+// memWAL is a WAL kept in memory, mainly used for testing. Records are stored encoded in the same way the disk-backed
+// WAL does, grouped by the segment they were logged into.
+type memWAL struct {
+	mtx      sync.Mutex
+	segments map[int][][]byte
+	// modified holds when each segment was last written to, mimicking file modtimes.
+	modified  map[int]time.Time
+	current   int
+	lastWrite time.Time
+	// closed is set by Close, after which writes fail with ErrClosed as in the disk-backed WAL.
+	closed bool
+}
+
+// NewMemWAL creates a new in-memory WAL, that can be used as a drop-in replacement of the disk-backed WAL in tests.
+func NewMemWAL() WAL {
+	return &memWAL{
+		segments: map[int][][]byte{0: nil},
+		modified: map[int]time.Time{0: time.Now()},
+	}
+}
+
+func (m *memWAL) Log(record *wal.Record) (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	return m.log(record), nil
+}
+
+// LogBatch appends all records to the current segment. Segments have no size limit, so a batch is never split.
+func (m *memWAL) LogBatch(records []*wal.Record) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for _, record := range records {
+		m.log(record)
+	}
+	return nil
+}
+
+// log appends record to the current segment, returning the encoded bytes written. Must be called with mtx held.
+func (m *memWAL) log(record *wal.Record) int {
+	// EncodeRecord never fails, and always encodes series then entries.
+	bufs, _ := EncodeRecord(record)
+	if len(bufs) == 0 {
+		return 0
+	}
+	var written int
+	for _, buf := range bufs {
+		m.segments[m.current] = append(m.segments[m.current], buf)
+		written += len(buf)
+	}
+	m.lastWrite = time.Now()
+	m.modified[m.current] = m.lastWrite
+	return written
+}
+
+// Delete drops all segments.
+func (m *memWAL) Delete() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.segments = map[int][][]byte{}
+	m.modified = map[int]time.Time{}
+	return nil
+}
+
+func (m *memWAL) Sync() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (m *memWAL) SyncContext(context.Context) error {
+	return m.Sync()
+}
+
+func (m *memWAL) Dir() string {
+	return memWALDir
+}
+
+func (m *memWAL) Close() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memWAL) NextSegment() (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	m.current++
+	m.segments[m.current] = nil
+	m.modified[m.current] = time.Now()
+	return m.current, nil
+}
+
+func (m *memWAL) Replay(handler func(*wal.Record) error) error {
+	m.mtx.Lock()
+	var records [][]byte
+	for _, segment := range m.segmentNumbers() {
+		records = append(records, m.segments[segment]...)
+	}
+	m.mtx.Unlock()
+
+	rec := &wal.Record{}
+	for _, b := range records {
+		rec.Reset()
+		if err := wal.DecodeRecord(b, rec); err != nil {
+			return fmt.Errorf("error decoding wal record: %w", err)
+		}
+		if err := handler(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memWAL) Size() (int64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var size int64
+	for _, records := range m.segments {
+		for _, b := range records {
+			size += int64(len(b))
+		}
+	}
+	return size, nil
+}
+
+func (m *memWAL) DeleteSegment(segmentNum int) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if _, ok := m.segments[segmentNum]; !ok {
+		return &SegmentError{SegmentNum: segmentNum, Op: "delete", Err: errSegmentNotFound}
+	}
+	delete(m.segments, segmentNum)
+	delete(m.modified, segmentNum)
+	return nil
+}
+
+func (m *memWAL) Truncate(upToSegment int) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	for segment := range m.segments {
+		if segment < upToSegment && segment != m.current {
+			delete(m.segments, segment)
+			delete(m.modified, segment)
+		}
+	}
+	return nil
+}
+
+func (m *memWAL) DeleteOlderThan(d time.Duration) (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return 0, ErrClosed
+	}
+	var deleted int
+	cutoff := time.Now().Add(-d)
+	for segment := range m.segments {
+		if segment != m.current && m.modified[segment].Before(cutoff) {
+			delete(m.segments, segment)
+			delete(m.modified, segment)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *memWAL) CurrentSegment() (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	segments := m.segmentNumbers()
+	if len(segments) == 0 {
+		return -1, nil
+	}
+	return segments[len(segments)-1], nil
+}
+
+func (m *memWAL) Segments() ([]int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.segmentNumbers(), nil
+}
+
+func (m *memWAL) CountSegments() (int, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return len(m.segments), nil
+}
+
+func (m *memWAL) Stats() (Stats, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	stats := Stats{
+		Segments:       len(m.segments),
+		OldestSegment:  -1,
+		NewestSegment:  -1,
+		LastWrite:      m.lastWrite,
+		SegmentRecords: make(map[int]int, len(m.segments)),
+	}
+	for segment, records := range m.segments {
+		for _, b := range records {
+			stats.Size += int64(len(b))
+		}
+		stats.SegmentRecords[segment] = len(records)
+	}
+	if segments := m.segmentNumbers(); len(segments) > 0 {
+		stats.OldestSegment = segments[0]
+		stats.NewestSegment = segments[len(segments)-1]
+	}
+	return stats, nil
+}
+
+func (m *memWAL) HeadSize() (int64, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	segments := m.segmentNumbers()
+	if len(segments) == 0 {
+		return 0, nil
+	}
+	var size int64
+	for _, b := range m.segments[segments[len(segments)-1]] {
+		size += int64(len(b))
+	}
+	return size, nil
+}
+
+func (m *memWAL) LastWriteTime() time.Time {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.lastWrite
+}
+
+// segmentNumbers returns the existing segment numbers in order. Must be called with mtx held.
+func (m *memWAL) segmentNumbers() []int {
+	numbers := make([]int, 0, len(m.segments))
+	for segment := range m.segments {
+		numbers = append(numbers, segment)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestMemWAL_BehavesLikeDiskWAL(t *testing.T) {
+	for name, newTestWAL := range map[string]func(t *testing.T) WAL{
+		"disk": func(t *testing.T) WAL {
+			w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			return w
+		},
+		"memory": func(t *testing.T) WAL {
+			return NewMemWAL()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := newTestWAL(t)
+			defer w.Close()
+
+			requireLog(t, w, newTestRecord(1, "first"))
+			segment, err := w.NextSegment()
+			require.NoError(t, err)
+			require.Equal(t, 1, segment)
+			requireLog(t, w, newTestRecord(2, "second"))
+			_, err = w.NextSegment()
+			require.NoError(t, err)
+			requireLog(t, w, newTestRecord(3, "third"))
+			require.NoError(t, w.Sync())
+
+			current, err := w.CurrentSegment()
+			require.NoError(t, err)
+			require.Equal(t, 2, current)
+			headSize, err := w.HeadSize()
+			require.NoError(t, err)
+			require.Positive(t, headSize)
+			segments, err := w.Segments()
+			require.NoError(t, err)
+			require.Equal(t, []int{0, 1, 2}, segments)
+
+			var lines []string
+			replay := func(rec *wal.Record) error {
+				for _, entries := range rec.RefEntries {
+					for _, e := range entries.Entries {
+						lines = append(lines, e.Line)
+					}
+				}
+				return nil
+			}
+			require.NoError(t, w.Replay(replay))
+			require.Equal(t, []string{"first", "second", "third"}, lines)
+
+			require.NoError(t, w.DeleteSegment(0))
+			require.Error(t, w.DeleteSegment(0))
+			require.NoError(t, w.Truncate(2))
+
+			// closed WALs can still be read, but not written
+			require.NoError(t, w.Close())
+			require.NoError(t, w.Close())
+			lines = nil
+			require.NoError(t, w.Replay(replay))
+			require.Equal(t, []string{"third"}, lines)
+			_, err = w.Log(newTestRecord(4, "fourth"))
+			require.ErrorIs(t, err, ErrClosed)
+			require.ErrorIs(t, w.Sync(), ErrClosed)
+			_, err = w.NextSegment()
+			require.ErrorIs(t, err, ErrClosed)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// Merge replays all records in the WAL directory srcDir, in order, and logs them into dst, returning the number of
+// records merged. The source is opened read-only with the default Config, so it must have been written without a
+// custom Encoder nor record checksums, and it's left unchanged. Corrupted source segments are handled as Replay does:
+// the rest of each is skipped with a warning, and merging continues with the next one. Entries of source segments
+// before its checkpoint were already delivered, so only their series are merged.
+//
+// Series refs are only unique within a WAL, so the refs of merged records are shifted past the highest ref found in
+// dst, which is synced and read whole first, regardless of its checkpoint, so that merged entries keep referencing
+// their own series when dst is replayed. Refs of records logged to dst afterwards must not collide with the shifted ones.
+func Merge(dst WAL, srcDir string, logger log.Logger) (int, error) {
+	if filepath.Clean(srcDir) == filepath.Clean(dst.Dir()) {
+		return 0, fmt.Errorf("cannot merge WAL %s into itself", srcDir)
+	}
+	src, err := New(Config{Enabled: true, Dir: srcDir, ReadOnly: true}, logger, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source WAL: %w", err)
+	}
+	defer src.Close()
+
+	offset, err := nextSeriesRef(dst)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read destination WAL: %w", err)
+	}
+	var merged int
+	err = src.Replay(func(rec *wal.Record) error {
+		for i := range rec.Series {
+			rec.Series[i].Ref += offset
+		}
+		for i := range rec.RefEntries {
+			rec.RefEntries[i].Ref += offset
+		}
+		if _, err := dst.Log(rec); err != nil {
+			return fmt.Errorf("failed to log merged record %d: %w", merged, err)
+		}
+		merged++
+		return nil
+	})
+	var corrupted *CorruptedSegmentsError
+	if errors.As(err, &corrupted) {
+		level.Warn(logger).Log("msg", "skipped corrupted segments merging WAL", "dir", srcDir, "segments", fmt.Sprint(corrupted.Segments), "err", err)
+		err = nil
+	}
+	if err != nil {
+		return merged, err
+	}
+	level.Info(logger).Log("msg", "merged WAL", "dir", srcDir, "records", merged)
+	return merged, nil
+}
+
+// nextSeriesRef returns the series ref following the highest one found in w, or 0 if w holds no records. WALs of this
+// package are scanned whole, since the checkpoint and replay filters could hide refs still in use.
+func nextSeriesRef(w WAL) (chunks.HeadSeriesRef, error) {
+	if err := w.Sync(); err != nil {
+		return 0, err
+	}
+	replay := w.Replay
+	if ww, ok := w.(*wrapper); ok {
+		replay = ww.scanAll
+	}
+	var next chunks.HeadSeriesRef
+	err := replay(func(rec *wal.Record) error {
+		for _, s := range rec.Series {
+			if s.Ref >= next {
+				next = s.Ref + 1
+			}
+		}
+		for _, entries := range rec.RefEntries {
+			if entries.Ref >= next {
+				next = entries.Ref + 1
+			}
+		}
+		return nil
+	})
+	var corrupted *CorruptedSegmentsError
+	if errors.As(err, &corrupted) {
+		err = nil
+	}
+	return next, err
+}
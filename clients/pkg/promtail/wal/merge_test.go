@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestMerge(t *testing.T) {
+	srcDir := t.TempDir()
+	// holds "first" and "third", in series 1 and 3, with the record holding "second" corrupted
+	newCorruptedWAL(t, srcDir, true)
+
+	dst, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	dstRec := newTestRecord(1, "primary")
+	dstRec.Series[0].Labels = labels.FromStrings("test", "primary")
+	requireLog(t, dst, dstRec)
+
+	merged, err := Merge(dst, srcDir, log.NewNopLogger())
+	require.NoError(t, err)
+	// series and entries are replayed as separate records
+	require.Equal(t, 4, merged)
+	require.NoError(t, dst.Sync())
+
+	labelsByRef := map[chunks.HeadSeriesRef]string{}
+	var lines []string
+	require.NoError(t, dst.Replay(func(rec *wal.Record) error {
+		for _, s := range rec.Series {
+			labelsByRef[s.Ref] = s.Labels.String()
+		}
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, fmt.Sprintf("%s %s", labelsByRef[entries.Ref], e.Line))
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{
+		`{test="primary"} primary`,
+		`{test="series-1"} first`,
+		`{test="series-3"} third`,
+	}, lines)
+
+	// the source is left unchanged
+	src, err := New(Config{Enabled: true, Dir: srcDir, ReadOnly: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	srcLines, err := replayLines(src)
+	require.Error(t, err)
+	require.Equal(t, []string{"first", "third"}, srcLines)
+
+	_, err = Merge(dst, dst.Dir(), log.NewNopLogger())
+	require.ErrorContains(t, err, "into itself")
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-kit/log/level"
+)
+
+// metaSuffix is appended to the name of a segment to name its metadata sidecar file. Since the result isn't a number,
+// sidecars are never listed as segments.
+const metaSuffix = ".meta"
+
+// metaName returns the name of the metadata sidecar of the given segment, when named as wlog does.
+func metaName(segmentNum int) string {
+	return defaultSegmentName(segmentNum) + metaSuffix
+}
+
+// WriteSegmentMeta persists data in a sidecar file next to the given segment, replacing any previous one, which is
+// useful to store delivery offsets or tenant metadata alongside it. The sidecar is written atomically, and removed
+// along with its segment. An error is returned if the segment doesn't exist.
+func (w *wrapper) WriteSegmentMeta(segmentNum int, data []byte) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	// removals run exclusively, so the segment can't be removed before its sidecar is written
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	if err := w.writeSegmentMeta(segmentNum, data); err != nil {
+		return &SegmentError{SegmentNum: segmentNum, Op: "write meta", Err: err}
+	}
+	return nil
+}
+
+// writeSegmentMeta writes the metadata sidecar of the given segment. Must be called with mtx held.
+func (w *wrapper) writeSegmentMeta(segmentNum int, data []byte) error {
+	if err := w.checkSegmentExists(segmentNum); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(w.wal.Dir(), ".meta-")
+	if err != nil {
+		return fmt.Errorf("error creating metadata: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metadata: %w", err)
+	}
+	return os.Rename(f.Name(), w.metaPath(segmentNum))
+}
+
+// ReadSegmentMeta returns the data last persisted with WriteSegmentMeta for the given segment. If there's none, the
+// returned error satisfies errors.Is(err, fs.ErrNotExist).
+func (w *wrapper) ReadSegmentMeta(segmentNum int) ([]byte, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	data, err := os.ReadFile(w.metaPath(segmentNum))
+	if err != nil {
+		return nil, fmt.Errorf("error reading segment %d metadata: %w", segmentNum, err)
+	}
+	return data, nil
+}
+
+// checkSegmentExists returns an error if there's no segment numbered segmentNum. Must be called with mtx held, either
+// for reading or writing.
+func (w *wrapper) checkSegmentExists(segmentNum int) error {
+	// segments are usually named as configured, so try that first to avoid listing the whole directory
+	_, err := w.fs.Stat(w.segmentPath(segmentNum))
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.number == segmentNum {
+			return nil
+		}
+	}
+	return errSegmentNotFound
+}
+
+// removeSegmentMeta removes the metadata sidecar of the given segment, if any, logging failures to do so, since a
+// leftover sidecar is harmless. Must be called with mtx held.
+func (w *wrapper) removeSegmentMeta(segmentNum int) {
+	if err := w.fs.Remove(w.metaPath(segmentNum)); err != nil && !os.IsNotExist(err) {
+		level.Warn(w.log).Log("msg", "failed to remove WAL segment metadata", "segment", segmentNum, "err", err)
+	}
+}
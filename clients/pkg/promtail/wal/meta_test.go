@@ -0,0 +1,66 @@
+package wal
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_SegmentMeta(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+
+	_, err = ww.ReadSegmentMeta(0)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorContains(t, ww.WriteSegmentMeta(1, []byte("offset=1")), "write meta segment 1: segment not found")
+
+	require.NoError(t, ww.WriteSegmentMeta(0, []byte("offset=1")))
+	require.NoError(t, ww.WriteSegmentMeta(0, []byte("offset=2")))
+	data, err := ww.ReadSegmentMeta(0)
+	require.NoError(t, err)
+	require.Equal(t, "offset=2", string(data))
+
+	// sidecars aren't segments
+	count, err := w.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	requireLog(t, w, newTestRecord(1, "line"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3, "no temporary files should be left behind")
+}
+
+func TestWAL_SegmentMetaRemovedWithSegment(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+	for i := 0; i < 3; i++ {
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	for i := 0; i <= 3; i++ {
+		require.NoError(t, ww.WriteSegmentMeta(i, []byte("meta")))
+	}
+
+	require.NoError(t, w.DeleteSegment(1))
+	require.NoFileExists(t, filepath.Join(dir, metaName(1)))
+	require.NoError(t, w.Truncate(3))
+	require.NoFileExists(t, filepath.Join(dir, metaName(0)))
+	require.NoFileExists(t, filepath.Join(dir, metaName(2)))
+
+	data, err := ww.ReadSegmentMeta(3)
+	require.NoError(t, err)
+	require.Equal(t, "meta", string(data))
+}
@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// mirroredLog is a writeLog duplicating all writes to a mirror. Only errors from the primary are returned, errors from
+// the mirror are reported to onMirrorErr instead, so that a failing mirror doesn't stop the WAL.
+type mirroredLog struct {
+	writeLog
+	mirror      writeLog
+	onMirrorErr func(op string, err error)
+}
+
+func (m *mirroredLog) Log(recs ...[]byte) error {
+	if err := m.mirror.Log(recs...); err != nil {
+		m.onMirrorErr("log", err)
+	}
+	return m.writeLog.Log(recs...)
+}
+
+func (m *mirroredLog) Sync() error {
+	if err := m.mirror.Sync(); err != nil {
+		m.onMirrorErr("sync", err)
+	}
+	return m.writeLog.Sync()
+}
+
+func (m *mirroredLog) Close() error {
+	if err := m.mirror.Close(); err != nil {
+		m.onMirrorErr("close", err)
+	}
+	return m.writeLog.Close()
+}
+
+func (m *mirroredLog) NextSegmentSync() (int, error) {
+	if _, err := m.mirror.NextSegmentSync(); err != nil {
+		m.onMirrorErr("next segment", err)
+	}
+	return m.writeLog.NextSegmentSync()
+}
+
+// openMirror opens the mirror wlog.WL for clientName and tenantID under cfg.MirrorDir, returning it along with its
+// directory.
+func openMirror(logger log.Logger, cfg Config, clientName, tenantID string) (writeLog, string, error) {
+	dir, err := cfg.dirUnder(cfg.MirrorDir, clientName, tenantID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkWritable(dir); err != nil {
+		return nil, "", err
+	}
+	if err := fillSegmentGaps(logger, dir); err != nil {
+		return nil, "", err
+	}
+	// wlog metrics are only registered for the primary WAL, since registering them twice would panic
+	mirror, err := wlog.NewSize(logger, nil, dir, cfg.segmentSize(), cfg.Compression)
+	if err != nil {
+		return nil, "", err
+	}
+	return mirror, dir, nil
+}
+
+// mirrorFailed logs and counts an error while writing to the mirror WAL.
+func (w *wrapper) mirrorFailed(op string, err error) {
+	level.Warn(w.log).Log("msg", "failed to mirror WAL operation", "op", op, "dir", w.mirrorDir, "err", err)
+	w.metrics.mirrorErrors.WithLabelValues(w.clientName, w.tenantID).Inc()
+}
+
+// removeSegment removes the segment file with the given name and number from the WAL directory along with its metadata
+// sidecar, and from the mirror directory if mirroring is enabled. Only errors removing the primary segment are
+// returned. Removed segments are queued to be notified to Config.OnSegmentDelete. Must be called with mtx held.
+func (w *wrapper) removeSegment(name string, number int) error {
+	err := w.fs.Remove(filepath.Join(w.wal.Dir(), name))
+	if err == nil {
+		level.Debug(w.log).Log("msg", "removed WAL segment", "segment", number, "name", name)
+		w.removeSegmentMeta(number)
+		if w.cfg.OnSegmentDelete != nil {
+			w.deletedSegments = append(w.deletedSegments, number)
+		}
+	}
+	if w.mirrorDir != "" {
+		if err := w.fs.Remove(filepath.Join(w.mirrorDir, name)); err != nil && !os.IsNotExist(err) {
+			w.mirrorFailed("remove segment", err)
+		}
+	}
+	return err
+}
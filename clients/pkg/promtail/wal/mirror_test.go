@@ -0,0 +1,62 @@
+package wal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWL is a writeLog whose writes always fail.
+type failingWL struct {
+	writeLog
+}
+
+func (failingWL) Log(...[]byte) error {
+	return errors.New("disk failure")
+}
+
+func TestWAL_Mirror(t *testing.T) {
+	dir, mirrorDir := t.TempDir(), t.TempDir()
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: dir, MirrorDir: mirrorDir}, "client", "tenant")
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "first"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(2, "second"))
+	require.NoError(t, w.Sync())
+	require.NoError(t, w.DeleteSegment(0))
+	require.NoError(t, w.Close())
+
+	// the mirror can be opened as a WAL on its own
+	for _, base := range []string{dir, mirrorDir} {
+		copied, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: base}, "client", "tenant")
+		require.NoError(t, err)
+		require.NoError(t, copied.Close())
+		require.Equal(t, []int{1, 2}, segmentNumbers(t, copied.Dir()))
+		lines, err := replayLines(copied)
+		require.NoError(t, err)
+		require.Equal(t, []string{"second"}, lines)
+	}
+
+	require.NoError(t, w.Delete())
+	require.NoDirExists(t, w.Dir())
+	require.NoDirExists(t, w.(*wrapper).mirrorDir)
+}
+
+func TestWAL_MirrorFailure(t *testing.T) {
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: t.TempDir(), MirrorDir: t.TempDir()}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+	mirrored := w.(*wrapper).wal.(*mirroredLog)
+	mirrored.mirror = failingWL{mirrored.mirror}
+
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.Equal(t, 1.0, testutil.ToFloat64(w.(*wrapper).metrics.mirrorErrors.WithLabelValues("client", "tenant")))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+}
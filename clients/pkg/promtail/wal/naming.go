@@ -0,0 +1,71 @@
+package wal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// defaultSegmentName names segments as wlog does, with their number zero padded to 8 digits.
+func defaultSegmentName(segmentNum int) string {
+	return fmt.Sprintf("%08d", segmentNum)
+}
+
+// defaultSegmentNumber parses the number of a segment from its name, accepting any decimal number, so that segments
+// named as wlog does are recognized.
+func defaultSegmentNumber(name string) (int, bool) {
+	n, err := strconv.Atoi(name)
+	return n, err == nil
+}
+
+// segmentName returns the name of the given segment, applying SegmentNameFunc if set.
+func (c *Config) segmentName(segmentNum int) string {
+	if c.SegmentNameFunc != nil {
+		return c.SegmentNameFunc(segmentNum)
+	}
+	return defaultSegmentName(segmentNum)
+}
+
+// segmentNumber parses the number of the segment named name, applying SegmentNumberFunc if set. It returns false if
+// name isn't a segment.
+func (c *Config) segmentNumber(name string) (int, bool) {
+	if c.SegmentNumberFunc != nil {
+		return c.SegmentNumberFunc(name)
+	}
+	return defaultSegmentNumber(name)
+}
+
+// segmentPath returns the path of the given segment in the WAL directory.
+func (w *wrapper) segmentPath(segmentNum int) string {
+	return filepath.Join(w.wal.Dir(), w.cfg.segmentName(segmentNum))
+}
+
+// metaPath returns the path of the metadata sidecar of the given segment in the WAL directory.
+func (w *wrapper) metaPath(segmentNum int) string {
+	return w.segmentPath(segmentNum) + metaSuffix
+}
+
+// segmentRefs lists the segments in the WAL directory, recognized as configured with SegmentNumberFunc. Must be called
+// with mtx held, either for reading or writing.
+func (w *wrapper) segmentRefs() ([]segmentRef, error) {
+	refs, err := readNamedSegmentRefs(w.fs, w.wal.Dir(), w.cfg.segmentNumber)
+	if err != nil {
+		return nil, fmt.Errorf("error reading segments in wal directory: %w", err)
+	}
+	return refs, nil
+}
+
+// openSegment opens the segment at path, returning a reader over its records. Unlike wlog.OpenReadSegment, the segment
+// doesn't need to be named as wlog does.
+func openSegment(path string) (*os.File, *wlog.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// buffer as many pages as wlog.NewSegmentBufReader does
+	return f, wlog.NewReader(bufio.NewReaderSize(f, 16*walPageSize)), nil
+}
@@ -0,0 +1,63 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_CustomSegmentNames(t *testing.T) {
+	name := func(segmentNum int) string { return fmt.Sprintf("segment-%d", segmentNum) }
+	number := func(name string) (int, bool) {
+		if !strings.HasPrefix(name, "segment-") {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "segment-"))
+		return n, err == nil
+	}
+
+	// segments written by wlog, then renamed by an external tool
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	require.NoError(t, w.Close())
+	for _, segment := range segmentNumbers(t, dir) {
+		require.NoError(t, os.Rename(filepath.Join(dir, defaultSegmentName(segment)), filepath.Join(dir, name(segment))))
+	}
+
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentNameFunc: name, SegmentNumberFunc: number}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+
+	// the segment wlog created on open isn't named as configured
+	segments, err := w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2}, segments)
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 0", "line 1", "line 2"}, lines)
+	r, err := ww.NewReader()
+	require.NoError(t, err)
+	require.True(t, r.Next())
+	require.NoError(t, r.Close())
+
+	require.NoError(t, ww.WriteSegmentMeta(1, []byte("meta")))
+	require.FileExists(t, filepath.Join(dir, "segment-1.meta"))
+	meta, err := ww.ReadSegmentMeta(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), meta)
+	require.NoError(t, w.DeleteSegment(1))
+	require.NoFileExists(t, filepath.Join(dir, "segment-1"))
+	require.NoFileExists(t, filepath.Join(dir, "segment-1.meta"))
+
+	segments, err = w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 2}, segments)
+	require.Error(t, w.DeleteSegment(1))
+}
@@ -0,0 +1,96 @@
+package wal
+
+import (
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// options holds the settings NewWAL builds a WAL from.
+type options struct {
+	cfg        Config
+	clientName string
+	tenantID   string
+	syncer     *Syncer
+}
+
+// Option configures a WAL built by NewWAL.
+type Option func(*options)
+
+// NewWAL creates a WAL configured by opts. Unlike New, the WAL is enabled unless a disabled Config is given with
+// WithConfig, and it can be created for a given client and tenant, in which case it's written under
+// dir/clientName/tenantID. An enabled WAL implements Extended.
+//
+//	w, err := wal.NewWAL(logger, reg, wal.WithDir(dir), wal.WithClientName("default"), wal.WithCompression(true))
+func NewWAL(log log.Logger, reg prometheus.Registerer, opts ...Option) (WAL, error) {
+	o := options{cfg: Config{Enabled: true}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newWAL(log, reg, o.cfg, o.clientName, o.tenantID, withSyncer(o.syncer))
+}
+
+// WithConfig replaces the whole configuration of the WAL with cfg. Options given after it still apply on top.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.cfg = cfg
+	}
+}
+
+// WithDir sets the directory the WAL is written under, see Config.Dir.
+func WithDir(dir string) Option {
+	return func(o *options) {
+		o.cfg.Dir = dir
+	}
+}
+
+// WithClientName sets the client the WAL is written for.
+func WithClientName(clientName string) Option {
+	return func(o *options) {
+		o.clientName = clientName
+	}
+}
+
+// WithTenant sets the tenant the WAL is written for.
+func WithTenant(tenantID string) Option {
+	return func(o *options) {
+		o.tenantID = tenantID
+	}
+}
+
+// WithSyncer makes the WAL be synced by s, shared with other WALs, when running in SyncModeInterval, see Syncer.
+func WithSyncer(s *Syncer) Option {
+	return func(o *options) {
+		o.syncer = s
+	}
+}
+
+// WithSegmentSize sets the size in bytes at which the WAL rotates to a new segment, see Config.SegmentSize.
+func WithSegmentSize(size int) Option {
+	return func(o *options) {
+		o.cfg.SegmentSize = size
+	}
+}
+
+// WithCompression enables or disables the compression of records, see Config.Compression.
+func WithCompression(enabled bool) Option {
+	return func(o *options) {
+		o.cfg.Compression = enabled
+	}
+}
+
+// WithMaxSize bounds the total size in bytes of the WAL segments, see Config.MaxSize.
+func WithMaxSize(size int64) Option {
+	return func(o *options) {
+		o.cfg.MaxSize = size
+	}
+}
+
+// WithSyncMode sets when writes are flushed to disk, with interval only used by SyncModeInterval, see Config.SyncMode.
+func WithSyncMode(mode SyncMode, interval time.Duration) Option {
+	return func(o *options) {
+		o.cfg.SyncMode = mode
+		o.cfg.SyncInterval = interval
+	}
+}
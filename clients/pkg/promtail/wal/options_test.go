@@ -0,0 +1,58 @@
+package wal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWAL(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWAL(log.NewNopLogger(), nil,
+		WithDir(dir),
+		WithClientName("client"),
+		WithTenant("tenant"),
+		WithSegmentSize(2*minSegmentSize),
+		WithCompression(true),
+		WithMaxSize(10*minSegmentSize),
+		WithSyncMode(SyncModePerRecord, 0),
+	)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.Equal(t, filepath.Join(dir, "client", "tenant"), w.Dir())
+	ww := w.(*wrapper)
+	require.Equal(t, "client", ww.clientName)
+	require.Equal(t, "tenant", ww.tenantID)
+	require.Equal(t, Config{
+		Enabled:     true,
+		Dir:         dir,
+		SegmentSize: 2 * minSegmentSize,
+		Compression: true,
+		MaxSize:     10 * minSegmentSize,
+		SyncMode:    SyncModePerRecord,
+	}, ww.cfg)
+
+	requireLog(t, w, newTestRecord(1, "line"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+}
+
+func TestNewWAL_WithConfig(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWAL(log.NewNopLogger(), nil, WithConfig(Config{Enabled: true, Dir: "ignored", RecordChecksums: true}), WithDir(dir))
+	require.NoError(t, err)
+	defer w.Close()
+	require.Equal(t, dir, w.Dir())
+	require.True(t, w.(*wrapper).cfg.RecordChecksums)
+
+	w, err = NewWAL(log.NewNopLogger(), nil, WithConfig(Config{Dir: dir}))
+	require.NoError(t, err)
+	require.True(t, IsNoop(w))
+
+	_, err = NewWAL(log.NewNopLogger(), nil, WithSegmentSize(1))
+	require.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package wal
+
+import (
+	"os"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+)
+
+// preallocateHead preallocates the segment currently being written if it wasn't yet, when Config.Preallocate is set.
+// Must be called with mtx held, either for reading or writing.
+func (w *wrapper) preallocateHead() {
+	if !w.cfg.Preallocate || w.preallocateFailed.Load() {
+		return
+	}
+	segment, err := w.currentSegment()
+	if err != nil {
+		level.Warn(w.log).Log("msg", "failed to find WAL segment to preallocate", "err", err)
+		return
+	}
+	w.preallocate(segment)
+}
+
+// preallocate reserves the disk space of the full segment size for segmentNum, keeping the file size unchanged. The
+// segment isn't extended to the full size, as truncating it would: wlog opens segments with O_APPEND, so records would
+// then be written after the extended size, past the segment size, and readers would see the zeros before them as
+// pages. Each segment is only preallocated once, and platforms without support for it silently skip it. On the first
+// failure, preallocation is logged and disabled. Must be called with mtx held, either for reading or writing.
+func (w *wrapper) preallocate(segmentNum int) {
+	if !w.cfg.Preallocate || w.preallocateFailed.Load() {
+		return
+	}
+	last := w.preallocated.Load()
+	if segmentNum <= int(last) || !w.preallocated.CompareAndSwap(last, int64(segmentNum)) {
+		return
+	}
+	f, err := os.OpenFile(wlog.SegmentName(w.wal.Dir(), segmentNum), os.O_WRONLY, 0o666)
+	if err == nil {
+		err = fileutil.Preallocate(f, int64(w.cfg.segmentSize()), false)
+		_ = f.Close()
+	}
+	if err != nil && w.preallocateFailed.CompareAndSwap(false, true) {
+		level.Warn(w.log).Log("msg", "failed to preallocate WAL segment, disabling preallocation", "segment", segmentNum, "err", err)
+	}
+}
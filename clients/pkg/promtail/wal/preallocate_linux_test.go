@@ -0,0 +1,56 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/require"
+)
+
+// allocatedSize returns the disk space allocated to the file at path, which can differ from its size.
+func allocatedSize(t *testing.T, path string) int64 {
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	return fi.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestWAL_Preallocate(t *testing.T) {
+	dir := t.TempDir()
+	// fileutil.Preallocate silently does nothing on filesystems not supporting it
+	f, err := os.Create(filepath.Join(dir, "probe"))
+	require.NoError(t, err)
+	require.NoError(t, fileutil.Preallocate(f, minSegmentSize, false))
+	require.NoError(t, f.Close())
+	if allocatedSize(t, f.Name()) < minSegmentSize {
+		t.Skip("filesystem doesn't support preallocation")
+	}
+
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize, Preallocate: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	requireAllocated := func(segment int) {
+		t.Helper()
+		path := wlog.SegmentName(dir, segment)
+		require.GreaterOrEqual(t, allocatedSize(t, path), int64(minSegmentSize))
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		// extending the file would make wlog append records after the preallocated space
+		require.Less(t, fi.Size(), int64(minSegmentSize), "file size must be unchanged")
+	}
+	requireAllocated(0)
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	segment, err := w.NextSegment()
+	require.NoError(t, err)
+	requireAllocated(segment)
+	requireLog(t, w, newTestRecord(2, "second"))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, lines)
+}
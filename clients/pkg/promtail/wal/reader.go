@@ -14,6 +14,9 @@ import (
 
 // ReadWAL will read all entries in the WAL located under dir. Mainly used for testing
 func ReadWAL(dir string) ([]api.Entry, error) {
+	if err := checkVersionFile(dir); err != nil {
+		return nil, err
+	}
 	reader, close, err := walUtils.NewWalReader(dir, -1)
 	if err != nil {
 		return nil, err
@@ -26,6 +29,11 @@ func ReadWAL(dir string) ([]api.Entry, error) {
 	for reader.Next() {
 		var walRec = wal.Record{}
 		bytes := reader.Record()
+		if isVersion, err := checkVersionRecord(bytes); err != nil {
+			return nil, err
+		} else if isVersion {
+			continue
+		}
 		err = wal.DecodeRecord(bytes, &walRec)
 		if err != nil {
 			return nil, fmt.Errorf("error decoding wal record: %w", err)
@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readerTracker keeps the segment each open RecordReader and Watch is positioned in, so that segments aren't removed
+// from under them.
+type readerTracker struct {
+	mtx    sync.Mutex
+	nextID int
+	// positions holds the segment each reader is positioned in, by reader ID, or -1 if the reader is done.
+	positions map[int]int
+	gauge     prometheus.Gauge
+}
+
+func newReaderTracker(gauge prometheus.Gauge) *readerTracker {
+	return &readerTracker{positions: map[int]int{}, gauge: gauge}
+}
+
+// open registers a reader positioned in segment, returning its ID.
+func (t *readerTracker) open(segment int) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	id := t.nextID
+	t.nextID++
+	t.positions[id] = segment
+	t.gauge.Set(float64(len(t.positions)))
+	return id
+}
+
+// move records that the reader with the given ID is now positioned in segment.
+func (t *readerTracker) move(id, segment int) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if _, ok := t.positions[id]; ok {
+		t.positions[id] = segment
+	}
+}
+
+// close unregisters the reader with the given ID. Closing it again is a no-op.
+func (t *readerTracker) close(id int) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.positions, id)
+	t.gauge.Set(float64(len(t.positions)))
+}
+
+// inUse returns whether a reader is positioned in segment.
+func (t *readerTracker) inUse(segment int) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	for _, position := range t.positions {
+		if position == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// oldest returns the lowest segment a reader is positioned in, or -1 if there's none. Readers only move forward, so
+// segments before it aren't needed by any reader.
+func (t *readerTracker) oldest() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	oldest := -1
+	for _, position := range t.positions {
+		if position >= 0 && (oldest < 0 || position < oldest) {
+			oldest = position
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,60 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrReadOnly is returned by operations modifying a WAL opened with Config.ReadOnly.
+var ErrReadOnly = errors.New("WAL is read-only")
+
+// readOnlyLog is the writeLog used when Config.ReadOnly is enabled. Unlike wlog, it doesn't create a new segment when
+// opened, and rejects all writes.
+type readOnlyLog struct {
+	dir string
+}
+
+// openReadOnlyLog opens the existing WAL directory dir for reading, without creating nor modifying anything in it.
+func openReadOnlyLog(dir string) (*readOnlyLog, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open read-only WAL: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("failed to open read-only WAL: %q is not a directory", dir)
+	}
+	return &readOnlyLog{dir: dir}, nil
+}
+
+func (r *readOnlyLog) Log(...[]byte) error {
+	return ErrReadOnly
+}
+
+func (r *readOnlyLog) Sync() error {
+	return nil
+}
+
+func (r *readOnlyLog) Close() error {
+	return nil
+}
+
+func (r *readOnlyLog) Dir() string {
+	return r.dir
+}
+
+func (r *readOnlyLog) NextSegmentSync() (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (r *readOnlyLog) Repair(error) error {
+	return ErrReadOnly
+}
+
+// checkWrite returns ErrReadOnly if the WAL was opened with Config.ReadOnly.
+func (w *wrapper) checkWrite() error {
+	if w.cfg.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
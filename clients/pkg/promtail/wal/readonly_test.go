@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// dirContents returns the names and sizes of the files in dir.
+func dirContents(t *testing.T, dir string) map[string]int64 {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	contents := map[string]int64{}
+	for _, e := range entries {
+		fi, err := e.Info()
+		require.NoError(t, err)
+		contents[e.Name()] = fi.Size()
+	}
+	return contents
+}
+
+func TestWAL_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 2)
+	require.NoError(t, w.Close())
+	before := dirContents(t, dir)
+
+	w, err := New(Config{Enabled: true, Dir: dir, ReadOnly: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 0", "line 1"}, lines)
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	require.True(t, r.Next())
+	require.NoError(t, r.Close())
+	// no segment is created when opening the WAL
+	segments, err := w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1}, segments)
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Segments)
+
+	_, err = w.Log(newTestRecord(2, "line 2"))
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, w.LogBatch([]*wal.Record{newTestRecord(2, "line 2")}), ErrReadOnly)
+	_, err = w.NextSegment()
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, w.DeleteSegment(0), ErrReadOnly)
+	require.ErrorIs(t, w.Truncate(1), ErrReadOnly)
+	_, err = w.DeleteOlderThan(0)
+	require.ErrorIs(t, err, ErrReadOnly)
+	require.ErrorIs(t, w.(Extended).Compact(), ErrReadOnly)
+	require.ErrorIs(t, w.(Extended).WriteSegmentMeta(0, []byte("meta")), ErrReadOnly)
+	require.ErrorIs(t, w.Delete(), ErrReadOnly)
+	require.NoError(t, w.Sync())
+	require.NoError(t, w.Close())
+
+	require.Equal(t, before, dirContents(t, dir))
+
+	t.Run("missing dir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+		_, err := New(Config{Enabled: true, Dir: dir, ReadOnly: true}, log.NewNopLogger(), nil)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		require.NoDirExists(t, dir)
+	})
+}
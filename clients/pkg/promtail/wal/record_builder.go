@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// RecordBuilder builds records to Log, checking that every entry references a series of the record, since Log can't
+// tell malformed records apart from entries referencing series logged earlier. Entries of the same series are grouped,
+// in the order they're added. A RecordBuilder is not safe for concurrent use.
+//
+//	b := NewRecordBuilder()
+//	b.AddSeries(ref, lbs)
+//	b.AddEntry(ref, ts, line)
+//	rec, err := b.Build()
+type RecordBuilder struct {
+	series  map[chunks.HeadSeriesRef]labels.Labels
+	entries map[chunks.HeadSeriesRef]int
+	rec     *wal.Record
+	err     error
+}
+
+// NewRecordBuilder returns an empty RecordBuilder.
+func NewRecordBuilder() *RecordBuilder {
+	return &RecordBuilder{
+		series:  map[chunks.HeadSeriesRef]labels.Labels{},
+		entries: map[chunks.HeadSeriesRef]int{},
+		rec:     &wal.Record{},
+	}
+}
+
+// AddSeries adds a series with the given ref and labels. Adding the same series twice is a no-op, while adding the
+// same ref with different labels fails the build.
+func (b *RecordBuilder) AddSeries(ref chunks.HeadSeriesRef, lbs labels.Labels) {
+	if existing, ok := b.series[ref]; ok {
+		if !labels.Equal(existing, lbs) && b.err == nil {
+			b.err = fmt.Errorf("series ref %d added with labels %s and %s", ref, existing, lbs)
+		}
+		return
+	}
+	b.series[ref] = lbs
+	b.rec.Series = append(b.rec.Series, record.RefSeries{Ref: ref, Labels: lbs})
+}
+
+// AddEntry adds an entry to the series with the given ref, which must be added to the builder too, before or after.
+func (b *RecordBuilder) AddEntry(ref chunks.HeadSeriesRef, ts time.Time, line string) {
+	i, ok := b.entries[ref]
+	if !ok {
+		i = len(b.rec.RefEntries)
+		b.entries[ref] = i
+		b.rec.RefEntries = append(b.rec.RefEntries, wal.RefEntries{Ref: ref})
+	}
+	b.rec.RefEntries[i].Entries = append(b.rec.RefEntries[i].Entries, logproto.Entry{Timestamp: ts, Line: line})
+}
+
+// Build returns the record built, or an error if it's empty, being ErrEmptyRecord, if an entry references a series not
+// added, or if a series ref was added with different labels. The builder must not be used after Build.
+func (b *RecordBuilder) Build() (*wal.Record, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.rec.Series) == 0 && len(b.rec.RefEntries) == 0 {
+		return nil, ErrEmptyRecord
+	}
+	for _, entries := range b.rec.RefEntries {
+		if _, ok := b.series[entries.Ref]; !ok {
+			return nil, fmt.Errorf("entries reference series ref %d, which wasn't added", entries.Ref)
+		}
+	}
+	return b.rec, nil
+}
@@ -0,0 +1,64 @@
+package wal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordBuilder(t *testing.T) {
+	ts := time.Unix(1_000_000, 0)
+	b := NewRecordBuilder()
+	b.AddEntry(2, ts, "before its series")
+	b.AddSeries(1, labels.FromStrings("app", "first"))
+	b.AddSeries(2, labels.FromStrings("app", "second"))
+	b.AddSeries(1, labels.FromStrings("app", "first"))
+	b.AddEntry(1, ts, "first")
+	b.AddEntry(2, ts.Add(time.Second), "second")
+	rec, err := b.Build()
+	require.NoError(t, err)
+
+	require.Len(t, rec.Series, 2)
+	require.Equal(t, chunks.HeadSeriesRef(1), rec.Series[0].Ref)
+	require.Equal(t, `{app="second"}`, rec.Series[1].Labels.String())
+	// entries are grouped by series, in the order they were added
+	require.Len(t, rec.RefEntries, 2)
+	require.Equal(t, chunks.HeadSeriesRef(2), rec.RefEntries[0].Ref)
+	require.Equal(t, "before its series", rec.RefEntries[0].Entries[0].Line)
+	require.Equal(t, "second", rec.RefEntries[0].Entries[1].Line)
+	require.Equal(t, ts.Add(time.Second), rec.RefEntries[0].Entries[1].Timestamp)
+
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	requireLog(t, w, rec)
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"before its series", "second", "first"}, lines)
+}
+
+func TestRecordBuilder_Errors(t *testing.T) {
+	t.Run("unknown series", func(t *testing.T) {
+		b := NewRecordBuilder()
+		b.AddSeries(1, labels.FromStrings("app", "first"))
+		b.AddEntry(1, time.Now(), "line")
+		b.AddEntry(3, time.Now(), "line")
+		_, err := b.Build()
+		require.EqualError(t, err, "entries reference series ref 3, which wasn't added")
+	})
+	t.Run("conflicting labels", func(t *testing.T) {
+		b := NewRecordBuilder()
+		b.AddSeries(1, labels.FromStrings("app", "first"))
+		b.AddSeries(1, labels.FromStrings("app", "other"))
+		_, err := b.Build()
+		require.EqualError(t, err, `series ref 1 added with labels {app="first"} and {app="other"}`)
+	})
+	t.Run("empty", func(t *testing.T) {
+		_, err := NewRecordBuilder().Build()
+		require.ErrorIs(t, err, ErrEmptyRecord)
+	})
+}
@@ -0,0 +1,219 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/prometheus/tsdb/wlog"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// RecordReader iterates over the records persisted in a WAL, decoding them lazily as the iteration advances. It's
+// the pull-based counterpart of WAL.Replay. A RecordReader is not safe for concurrent use.
+//
+//	r, err := w.NewReader()
+//	...
+//	defer r.Close()
+//	for r.Next() {
+//		rec := r.Record()
+//		...
+//	}
+//	if err := r.Err(); err != nil {
+//		...
+//	}
+type RecordReader struct {
+	dir      string
+	segments []int
+	next     int
+	pool     *wal.ResettingPool
+	// checksums is set when records are prefixed with a checksum, see Config.RecordChecksums.
+	checksums bool
+	encoder   Encoder
+	// segmentName names segments as configured, see Config.SegmentNameFunc.
+	segmentName func(int) string
+	// readers tracks the position of the reader, registered with readerID, until it's closed.
+	readers  *readerTracker
+	readerID int
+
+	segment *os.File
+	// segmentNum is the number of the segment being read.
+	segmentNum int
+	reader     *wlog.Reader
+	rec        *wal.Record
+	current    *wal.Record
+	err        error
+
+	// spare is decoded into by Peek, so that the current record stays valid until the next call to Next.
+	spare   *wal.Record
+	peeked  bool
+	peekOK  bool
+	peekRec *wal.Record
+}
+
+// NewReader creates a RecordReader over all segments currently in the WAL directory. Segments created after the
+// reader are not read. Until the reader is closed, Truncate keeps the segments it still has to read.
+func (w *wrapper) NewReader() (*RecordReader, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return nil, err
+	}
+	r := &RecordReader{
+		dir:         w.wal.Dir(),
+		pool:        w.pool,
+		checksums:   w.cfg.RecordChecksums,
+		encoder:     w.cfg.Encoder,
+		segmentName: w.cfg.segmentName,
+		rec:         w.pool.GetRecord(),
+	}
+	for _, segment := range segments {
+		r.segments = append(r.segments, segment.number)
+	}
+	first := -1
+	if len(r.segments) > 0 {
+		first = r.segments[0]
+	}
+	r.readers, r.readerID = w.readers, w.readers.open(first)
+	return r, nil
+}
+
+// Next advances the reader to the next record, returning false when there are no more records or an error occurred.
+func (r *RecordReader) Next() bool {
+	if r.peeked {
+		r.peeked = false
+		if !r.peekOK {
+			return false
+		}
+		r.current = r.peekRec
+		r.rec, r.spare = r.spare, r.rec
+		return true
+	}
+	var ok bool
+	r.current, ok = r.read(r.rec)
+	return ok
+}
+
+// Peek returns the next record without advancing the reader, so that the following call to Next returns the same
+// record. It returns false when there are no more records or an error occurred. The record returned by Record stays
+// valid, and the peeked one is only valid until the call to Next after the one returning it.
+func (r *RecordReader) Peek() (*wal.Record, bool) {
+	if !r.peeked {
+		if r.spare == nil {
+			r.spare = r.pool.GetRecord()
+		}
+		r.peekRec, r.peekOK = r.read(r.spare)
+		r.peeked = true
+	}
+	return r.peekRec, r.peekOK
+}
+
+// SeekTime advances the reader past the records whose entries are all older than t, so that the following call to
+// Next returns the first record holding an entry at or after t. Records holding no entries, such as the ones series
+// are logged in, are skipped too, so callers resolving series refs must track them separately. Segments aren't
+// indexed by time, so all records before the returned one are read and decoded. It returns the error that stopped
+// the reader, if any, and nil if no record qualifies, in which case Next returns false.
+func (r *RecordReader) SeekTime(t time.Time) error {
+	for {
+		rec, ok := r.Peek()
+		if !ok {
+			return r.err
+		}
+		if newest, ok := newestEntry(rec); ok && !newest.Before(t) {
+			return nil
+		}
+		r.Next()
+	}
+}
+
+// read decodes the next record into rec, returning the decoded record.
+func (r *RecordReader) read(rec *wal.Record) (*wal.Record, bool) {
+	if r.err != nil {
+		return nil, false
+	}
+	for {
+		if r.reader == nil {
+			if r.next >= len(r.segments) {
+				// done with all segments, so none of them is in use anymore
+				r.readers.move(r.readerID, -1)
+				return nil, false
+			}
+			if r.err = r.openSegment(r.segments[r.next]); r.err != nil {
+				return nil, false
+			}
+			r.next++
+		}
+		if r.reader.Next() {
+			isVersion, err := checkVersionRecord(r.reader.Record())
+			if err != nil {
+				r.err = err
+				return nil, false
+			}
+			if isVersion {
+				continue
+			}
+			rec.Reset()
+			decoded, err := decodeRecord(r.reader.Record(), rec, r.checksums, r.encoder)
+			if err != nil {
+				r.err = fmt.Errorf("error decoding wal record in segment %d at offset %d: %w", r.segmentNum, r.reader.Offset(), err)
+				return nil, false
+			}
+			return decoded, true
+		}
+		if err := r.reader.Err(); err != nil {
+			r.err = fmt.Errorf("error reading wal segment %d at offset %d: %w", r.segmentNum, r.reader.Offset(), err)
+			return nil, false
+		}
+		r.closeSegment()
+	}
+}
+
+// Record returns the record the reader is positioned at. The returned record is reused by the reader, and it's only
+// valid until the next call to Next.
+func (r *RecordReader) Record() *wal.Record {
+	return r.current
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (r *RecordReader) Err() error {
+	return r.err
+}
+
+// Close releases the resources held by the reader.
+func (r *RecordReader) Close() error {
+	r.readers.close(r.readerID)
+	r.closeSegment()
+	if r.rec != nil {
+		r.pool.PutRecord(r.rec)
+		r.rec = nil
+	}
+	if r.spare != nil {
+		r.pool.PutRecord(r.spare)
+		r.spare = nil
+	}
+	return nil
+}
+
+func (r *RecordReader) openSegment(segmentNum int) error {
+	if err := checkVersionFile(r.dir); err != nil {
+		return err
+	}
+	segment, reader, err := openSegment(filepath.Join(r.dir, r.segmentName(segmentNum)))
+	if err != nil {
+		return fmt.Errorf("error opening wal segment %d: %w", segmentNum, err)
+	}
+	r.segment, r.segmentNum, r.reader = segment, segmentNum, reader
+	r.readers.move(r.readerID, segmentNum)
+	return nil
+}
+
+func (r *RecordReader) closeSegment() {
+	if r.segment != nil {
+		_ = r.segment.Close()
+	}
+	r.segment = nil
+	r.reader = nil
+}
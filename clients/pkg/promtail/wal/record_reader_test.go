@@ -0,0 +1,177 @@
+package wal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+func TestRecordReader(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	requireLog(t, w, newTestRecord(3, "line 3"))
+	w.Close()
+
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+
+	var series []uint64
+	var lines []string
+	for r.Next() {
+		rec := r.Record()
+		for _, s := range rec.Series {
+			series = append(series, uint64(s.Ref))
+		}
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+	}
+	require.NoError(t, r.Err())
+	require.Equal(t, []uint64{0, 1, 2, 3}, series)
+	require.Equal(t, []string{"line 0", "line 1", "line 2", "line 3"}, lines)
+	require.False(t, r.Next(), "exhausted reader should not advance")
+}
+
+func TestRecordReader_Peek(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 2)
+	w.Close()
+
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+
+	var series []uint64
+	var lines []string
+	for {
+		peeked, ok := r.Peek()
+		again, againOK := r.Peek()
+		require.Equal(t, ok, againOK)
+		require.Same(t, peeked, again, "peeking twice should not advance")
+		if !ok {
+			break
+		}
+		require.True(t, r.Next())
+		require.Same(t, peeked, r.Record())
+
+		// peeking doesn't invalidate the current record
+		current := r.Record()
+		currentSeries, currentEntries := len(current.Series), len(current.RefEntries)
+		if next, ok := r.Peek(); ok {
+			require.NotSame(t, current, next)
+		}
+		require.Len(t, current.Series, currentSeries)
+		require.Len(t, current.RefEntries, currentEntries)
+
+		for _, s := range current.Series {
+			series = append(series, uint64(s.Ref))
+		}
+		for _, entries := range current.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+	}
+	require.NoError(t, r.Err())
+	require.Equal(t, []uint64{0, 1}, series)
+	require.Equal(t, []string{"line 0", "line 1"}, lines)
+
+	_, ok := r.Peek()
+	require.False(t, ok, "peeking at the end of the reader should return false")
+	require.False(t, r.Next())
+}
+
+func TestRecordReader_KeepsSegmentsOnTruncate(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 4)
+	defer w.Close()
+	wr := w.(*wrapper)
+	openReaders := func() float64 {
+		return testutil.ToFloat64(wr.metrics.openReaders.WithLabelValues("", ""))
+	}
+
+	r, err := wr.NewReader()
+	require.NoError(t, err)
+	require.Equal(t, 1.0, openReaders())
+	// the reader is positioned in the first segment even before reading it
+	require.NoError(t, w.Truncate(1))
+	require.Equal(t, []int{0, 1, 2, 3}, segmentNumbers(t, dir))
+
+	// advance to the entries of the second segment
+	for r.Next() {
+		if len(r.Record().RefEntries) > 0 && r.Record().RefEntries[0].Entries[0].Line == "line 1" {
+			break
+		}
+	}
+	require.NoError(t, r.Err())
+	// truncating keeps the segments the reader still has to read
+	require.NoError(t, w.Truncate(3))
+	require.Equal(t, []int{1, 2, 3}, segmentNumbers(t, dir))
+
+	r2, err := wr.NewReader()
+	require.NoError(t, err)
+	require.Equal(t, 2.0, openReaders())
+	require.NoError(t, r2.Close())
+	require.NoError(t, r.Close())
+	// closing twice is a no-op
+	require.NoError(t, r.Close())
+	require.Zero(t, openReaders())
+
+	require.NoError(t, w.Truncate(3))
+	require.Equal(t, []int{3}, segmentNumbers(t, dir))
+}
+
+func TestRecordReader_SeekTime(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	base := time.Unix(1_000_000, 0)
+	// entries-only records, one per minute, each holding an entry 10 minutes older too
+	for i := 0; i < 6; i++ {
+		rec := &wal.Record{RefEntries: []wal.RefEntries{{Ref: 1, Entries: []logproto.Entry{
+			{Timestamp: base.Add(time.Duration(i-10) * time.Minute), Line: fmt.Sprintf("old %d", i)},
+			{Timestamp: base.Add(time.Duration(i) * time.Minute), Line: fmt.Sprintf("line %d", i)},
+		}}}}
+		requireLog(t, w, rec)
+		if i%2 == 1 {
+			_, err := w.NextSegment()
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, w.Close())
+
+	seekLines := func(t *testing.T, ts time.Time) []string {
+		r, err := w.(Extended).NewReader()
+		require.NoError(t, err)
+		defer r.Close()
+		require.NoError(t, r.SeekTime(ts))
+		var lines []string
+		for r.Next() {
+			lines = append(lines, r.Record().RefEntries[0].Entries[1].Line)
+		}
+		require.NoError(t, r.Err())
+		return lines
+	}
+
+	t.Run("exact timestamp", func(t *testing.T) {
+		require.Equal(t, []string{"line 3", "line 4", "line 5"}, seekLines(t, base.Add(3*time.Minute)))
+	})
+	t.Run("between records", func(t *testing.T) {
+		require.Equal(t, []string{"line 2", "line 3", "line 4", "line 5"}, seekLines(t, base.Add(90*time.Second)))
+	})
+	t.Run("before all records", func(t *testing.T) {
+		require.Len(t, seekLines(t, base.Add(-time.Hour)), 6)
+	})
+	t.Run("after all records", func(t *testing.T) {
+		require.Empty(t, seekLines(t, base.Add(time.Hour)))
+	})
+}
@@ -0,0 +1,347 @@
+package wal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// CorruptedSegmentsError is returned when replaying a WAL in which some segments could not be fully read or decoded.
+// Replaying continues after a corrupted segment, so all records before the corruption point in each segment, and all
+// records in the remaining segments, have been handled.
+type CorruptedSegmentsError struct {
+	// Segments holds the numbers of the segments that were skipped, in order.
+	Segments []int
+	// Errs holds the error found in each of the skipped segments.
+	Errs []error
+}
+
+func (e *CorruptedSegmentsError) Error() string {
+	errs := make([]string, 0, len(e.Errs))
+	for i, err := range e.Errs {
+		errs = append(errs, fmt.Sprintf("segment %d: %v", e.Segments[i], err))
+	}
+	return fmt.Sprintf("skipped %d corrupted WAL segments: %s", len(e.Segments), strings.Join(errs, "; "))
+}
+
+// Replay reads all segments in the WAL directory, decoding each record and passing it to handler. Series and entries
+// logged together are stored, and therefore replayed, as separate records. The record passed to handler is reused
+// across calls, and must not be retained after handler returns. Only records already flushed to disk are read, so
+// callers replaying a live WAL should Sync it first. Since the WAL is locked for reading while replaying, handler must
+// not call other WAL methods, which would deadlock as soon as a write, or a rotation, is waiting for the lock.
+//
+// If a segment can't be read or decoded, the rest of it is skipped and replaying continues with the next one. In that
+// case a *CorruptedSegmentsError is returned once all segments have been read. Skipped segments are counted in the
+// promtail_wal_corruptions_total metric, and promtail_wal_last_corruption_timestamp_seconds tracks the last one. An
+// error returned by handler stops the replay and is returned as is.
+//
+// Segments before the last Checkpoint, or Truncate, were already delivered, so only their series are replayed, as
+// records with no entries, for the entries of the following segments to reference them.
+//
+// Entries may reference series logged in an earlier record, possibly in an earlier segment, so handlers must keep
+// track of the series seen so far. Entries referencing series not found earlier in the replay, for example because
+// the segment holding them was removed, are still passed to handler, and a warning is logged for each such series.
+func (w *wrapper) Replay(handler func(*wal.Record) error) error {
+	return w.ReplayWithProgress(handler, nil)
+}
+
+// ReplayWithProgress replays the WAL as Replay does, calling progress, if not nil, after each segment has been read
+// with its number and the number of records handled so far, which allows reporting the progress of long replays.
+// Progress is also exposed in the promtail_wal_replay_progress_segments metric. Like handler, progress must not call
+// other WAL methods.
+func (w *wrapper) ReplayWithProgress(handler func(*wal.Record) error, progress func(segment int, recordsSoFar int)) error {
+	start := w.clock.Now()
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	checkpoint, err := w.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	rec := w.pool.GetRecord()
+	defer w.pool.PutRecord(rec)
+	replayed := w.metrics.replayProgress.WithLabelValues(w.clientName, w.tenantID)
+	replayed.Set(0)
+	var records int
+	defer func() { w.replayFinished(start, records) }()
+	series := newSeriesTracker()
+	counted := w.skipStale(start, w.countReplayed(func(rec *wal.Record) error {
+		for _, ref := range series.track(rec) {
+			level.Warn(w.log).Log("msg", "replayed WAL entries reference an unknown series", "ref", ref)
+		}
+		if err := handler(rec); err != nil {
+			return err
+		}
+		records++
+		return nil
+	}))
+	var corrupted *CorruptedSegmentsError
+	seriesOnly := onlySeries(counted)
+	for _, segment := range segments {
+		handle := counted
+		if segment.number < checkpoint {
+			handle = seriesOnly
+		}
+		corruption, err := w.replaySegment(segment.number, rec, handle)
+		if err != nil {
+			return err
+		}
+		replayed.Inc()
+		if progress != nil {
+			progress(segment.number, records)
+		}
+		if corruption != nil {
+			level.Warn(w.log).Log("msg", "skipping corrupted WAL segment", "segment", segment.number, "err", corruption)
+			w.corruptionSkipped()
+			if corrupted == nil {
+				corrupted = &CorruptedSegmentsError{}
+			}
+			corrupted.Segments = append(corrupted.Segments, segment.number)
+			corrupted.Errs = append(corrupted.Errs, corruption)
+		}
+	}
+	if corrupted != nil {
+		return corrupted
+	}
+	return nil
+}
+
+// corruptionSkipped counts a corrupted segment skipped by a replay, tracking when it happened.
+func (w *wrapper) corruptionSkipped() {
+	w.metrics.corruptions.WithLabelValues(w.clientName, w.tenantID).Inc()
+	w.metrics.lastCorruption.WithLabelValues(w.clientName, w.tenantID).Set(float64(w.clock.Now().UnixNano()) / 1e9)
+}
+
+// replaySegment reads and decodes all records in a segment, passing each to handler. Errors reading or decoding the
+// segment are returned as corruption, while errors returned by handler are returned as err.
+func (w *wrapper) replaySegment(segmentNum int, rec *wal.Record, handler func(*wal.Record) error) (corruption, err error) {
+	if err := checkVersionFile(w.wal.Dir()); err != nil {
+		return nil, err
+	}
+	segment, reader, err := openSegment(w.segmentPath(segmentNum))
+	if err != nil {
+		return err, nil
+	}
+	defer segment.Close()
+
+	for reader.Next() {
+		if isVersion, err := checkVersionRecord(reader.Record()); isVersion {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rec.Reset()
+		decoded, err := decodeRecord(reader.Record(), rec, w.cfg.RecordChecksums, w.cfg.Encoder)
+		if err != nil {
+			return fmt.Errorf("error decoding wal record at offset %d: %w", reader.Offset(), err), nil
+		}
+		if err := handler(decoded); err != nil {
+			return nil, err
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return fmt.Errorf("error reading wal at offset %d: %w", reader.Offset(), err), nil
+	}
+	return nil, nil
+}
+
+// Drain replays the WAL as Replay does, removing each segment once all its records have been handled, so that disk
+// space is reclaimed as the replay advances. The segment being written to is first rotated, if it holds any record
+// besides the version header, so that all records logged before Drain are drained. An error returned by handler, or a
+// corrupted segment, stops draining and is returned, leaving that segment and the following ones in place. Only the
+// series of segments before the last Checkpoint, or Truncate, are replayed, as Replay does. Since the WAL is locked
+// while draining, handler must not call other WAL methods. Progress is exposed in the
+// promtail_wal_replay_progress_segments metric.
+func (w *wrapper) Drain(handler func(*wal.Record) error) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	start := w.clock.Now()
+	var records int
+	defer func() { w.replayFinished(start, records) }()
+	counted := w.skipStale(start, w.countReplayed(func(rec *wal.Record) error {
+		if err := handler(rec); err != nil {
+			return err
+		}
+		records++
+		return nil
+	}))
+	defer w.notifySegmentsDeleted()
+	defer w.notifySegmentsCreated()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	if err := w.flushBuffer(); err != nil {
+		return err
+	}
+	headSize, err := w.headSegmentSize()
+	if err != nil {
+		return err
+	}
+	if headSize > 0 && !w.headHoldsOnlyVersion(headSize) {
+		segment, err := w.wal.NextSegmentSync()
+		if err != nil {
+			return err
+		}
+		level.Debug(w.log).Log("msg", "rotated WAL to a new segment before draining", "segment", segment)
+		w.metrics.segmentsCreated.WithLabelValues(w.clientName, w.tenantID).Inc()
+		w.segmentCreated(segment)
+		w.preallocate(segment)
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	checkpoint, err := w.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	rec := w.pool.GetRecord()
+	defer w.pool.PutRecord(rec)
+	replayed := w.metrics.replayProgress.WithLabelValues(w.clientName, w.tenantID)
+	replayed.Set(0)
+	// the last segment is the one being written to, which is empty at this point
+	seriesOnly := onlySeries(counted)
+	for _, segment := range segments[:len(segments)-1] {
+		handle := counted
+		if segment.number < checkpoint {
+			handle = seriesOnly
+		}
+		corruption, err := w.replaySegment(segment.number, rec, handle)
+		if err != nil {
+			return err
+		}
+		replayed.Inc()
+		if corruption != nil {
+			return fmt.Errorf("error draining corrupted WAL segment %d: %w", segment.number, corruption)
+		}
+		if err := w.removeSegment(segment.name, segment.number); err != nil {
+			return err
+		}
+		if err := w.dirChanged(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onlySeries wraps handler so that only the series of records are passed to it, skipping records without series, for
+// segments before the checkpoint, whose entries were already delivered.
+func onlySeries(handler func(*wal.Record) error) func(*wal.Record) error {
+	return func(rec *wal.Record) error {
+		if len(rec.Series) == 0 {
+			return nil
+		}
+		rec.RefEntries = rec.RefEntries[:0]
+		return handler(rec)
+	}
+}
+
+// countReplayed wraps handler so that the records it handles successfully are counted in the
+// promtail_wal_replayed_records_total metric.
+func (w *wrapper) countReplayed(handler func(*wal.Record) error) func(*wal.Record) error {
+	replayed := w.metrics.replayedRecords.WithLabelValues(w.clientName, w.tenantID)
+	return func(rec *wal.Record) error {
+		if err := handler(rec); err != nil {
+			return err
+		}
+		replayed.Inc()
+		return nil
+	}
+}
+
+// skipStale wraps handler so that records whose newest entry is older than Config.MaxRecordAge before start aren't
+// passed to it, see Config.MaxRecordAge.
+func (w *wrapper) skipStale(start time.Time, handler func(*wal.Record) error) func(*wal.Record) error {
+	if w.cfg.MaxRecordAge <= 0 {
+		return handler
+	}
+	cutoff := start.Add(-w.cfg.MaxRecordAge)
+	skipped := w.metrics.staleSkipped.WithLabelValues(w.clientName, w.tenantID)
+	return func(rec *wal.Record) error {
+		newest, ok := newestEntry(rec)
+		if !ok || !newest.Before(cutoff) {
+			return handler(rec)
+		}
+		skipped.Inc()
+		if len(rec.Series) == 0 {
+			return nil
+		}
+		// later entries may reference these series
+		rec.RefEntries = nil
+		return handler(rec)
+	}
+}
+
+// newestEntry returns the timestamp of the newest entry in rec, and false if it holds no entries.
+func newestEntry(rec *wal.Record) (time.Time, bool) {
+	var newest time.Time
+	var found bool
+	for _, entries := range rec.RefEntries {
+		for _, e := range entries.Entries {
+			if !found || e.Timestamp.After(newest) {
+				newest = e.Timestamp
+				found = true
+			}
+		}
+	}
+	return newest, found
+}
+
+// replayFinished observes the duration of a replay started at start, unless it read no records, so that replaying
+// empty WALs doesn't skew the duration metric.
+func (w *wrapper) replayFinished(start time.Time, records int) {
+	if records == 0 {
+		return
+	}
+	w.metrics.replayDuration.WithLabelValues(w.clientName, w.tenantID).Observe(w.clock.Now().Sub(start).Seconds())
+}
+
+// seriesTracker keeps track of the series refs seen while replaying a WAL, to spot entries referencing unknown series.
+type seriesTracker struct {
+	seen    map[chunks.HeadSeriesRef]struct{}
+	unknown map[chunks.HeadSeriesRef]struct{}
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		seen:    map[chunks.HeadSeriesRef]struct{}{},
+		unknown: map[chunks.HeadSeriesRef]struct{}{},
+	}
+}
+
+// track registers the series in rec, returning the refs of its entries that reference a series not seen before, and
+// not already returned by a previous call.
+func (t *seriesTracker) track(rec *wal.Record) []chunks.HeadSeriesRef {
+	for _, s := range rec.Series {
+		t.seen[s.Ref] = struct{}{}
+	}
+	var unknown []chunks.HeadSeriesRef
+	for _, entries := range rec.RefEntries {
+		if _, ok := t.seen[entries.Ref]; ok {
+			continue
+		}
+		if _, ok := t.unknown[entries.Ref]; ok {
+			continue
+		}
+		t.unknown[entries.Ref] = struct{}{}
+		unknown = append(unknown, entries.Ref)
+	}
+	return unknown
+}
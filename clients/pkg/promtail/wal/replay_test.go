@@ -0,0 +1,372 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// replayLines replays w, returning all entry lines found.
+func replayLines(w WAL) ([]string, error) {
+	var lines []string
+	err := w.Replay(func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+		return nil
+	})
+	return lines, err
+}
+
+// corruptSegment overwrites segment content at the given offset.
+func corruptSegment(t *testing.T, dir string, segment int, offset int64) {
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%08d", segment)), os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), offset)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// newCorruptedWAL creates a closed WAL in dir holding the lines "first" and "second" in segment 0, with the record
+// holding "second" corrupted, and "third" in segment 1 if withNextSegment is set.
+func newCorruptedWAL(t *testing.T, dir string, withNextSegment bool) WAL {
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	fi, err := os.Stat(filepath.Join(dir, "00000000"))
+	require.NoError(t, err)
+	goodSize := fi.Size()
+	requireLog(t, w, newTestRecord(2, "second"))
+	if withNextSegment {
+		_, err = w.NextSegment()
+		require.NoError(t, err)
+		requireLog(t, w, newTestRecord(3, "third"))
+	}
+	w.Close()
+
+	corruptSegment(t, dir, 0, goodSize+10)
+	return w
+}
+
+func TestWAL_ReplaySkipsCorruptedSegments(t *testing.T) {
+	w := newCorruptedWAL(t, t.TempDir(), true)
+
+	lines, err := replayLines(w)
+	require.Equal(t, []string{"first", "third"}, lines)
+	var corrupted *CorruptedSegmentsError
+	require.ErrorAs(t, err, &corrupted)
+	require.Equal(t, []int{0}, corrupted.Segments)
+}
+
+func TestWAL_ReplayCountsCorruptions(t *testing.T) {
+	w := newCorruptedWAL(t, t.TempDir(), true)
+	metrics := w.(*wrapper).metrics
+	require.Zero(t, testutil.ToFloat64(metrics.lastCorruption.WithLabelValues("", "")))
+
+	before := time.Now()
+	_, err := replayLines(w)
+	require.Error(t, err)
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.corruptions.WithLabelValues("", "")))
+	last := testutil.ToFloat64(metrics.lastCorruption.WithLabelValues("", ""))
+	require.GreaterOrEqual(t, last, float64(before.UnixNano())/1e9)
+	require.LessOrEqual(t, last, float64(time.Now().UnixNano())/1e9)
+
+	// every replay skipping the segment counts it again
+	_, err = replayLines(w)
+	require.Error(t, err)
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.corruptions.WithLabelValues("", "")))
+}
+
+func TestWAL_Repair(t *testing.T) {
+	dir := t.TempDir()
+	newCorruptedWAL(t, dir, false)
+
+	w, err := New(Config{Enabled: true, Dir: dir, RepairOnOpen: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(4, "fourth"))
+	require.NoError(t, w.Sync())
+	// repairing a clean WAL is a no-op
+	require.NoError(t, w.(Extended).Repair())
+	w.Close()
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "fourth"}, lines)
+}
+
+func TestWAL_Drain(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	defer w.Close()
+
+	var lines []string
+	var remaining [][]int
+	require.NoError(t, w.(Extended).Drain(func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+				remaining = append(remaining, segmentNumbers(t, dir))
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"line 0", "line 1", "line 2"}, lines)
+	// the head segment is rotated before draining, and drained segments are removed as soon as they're handled
+	require.Equal(t, [][]int{{0, 1, 2, 3}, {1, 2, 3}, {2, 3}}, remaining)
+	require.Equal(t, []int{3}, segmentNumbers(t, dir))
+
+	// an empty head segment isn't rotated again
+	require.NoError(t, w.(Extended).Drain(func(*wal.Record) error { return nil }))
+	require.Equal(t, []int{3}, segmentNumbers(t, dir))
+
+	requireLog(t, w, newTestRecord(4, "line 4"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 4"}, lines)
+}
+
+func TestWAL_DrainVersionHeaderOnly(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	size, err := w.HeadSize()
+	require.NoError(t, err)
+	require.Equal(t, int64(versionRecordSize), size)
+
+	// a head holding only the version header has nothing to drain, so it's neither rotated nor removed
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.(Extended).Drain(func(*wal.Record) error { return nil }))
+		require.Equal(t, []int{0}, segmentNumbers(t, dir))
+	}
+	size, err = w.HeadSize()
+	require.NoError(t, err)
+	require.Equal(t, int64(versionRecordSize), size)
+
+	requireLog(t, w, newTestRecord(1, "line"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+}
+
+func TestWAL_DrainStopsOnError(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	defer w.Close()
+
+	handlerErr := fmt.Errorf("handler failed")
+	err := w.(Extended).Drain(func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			if entries.Entries[0].Line == "line 1" {
+				return handlerErr
+			}
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, handlerErr)
+	require.Equal(t, []int{1, 2, 3}, segmentNumbers(t, dir))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 1", "line 2"}, lines)
+}
+
+func TestWAL_ReplayWithProgress(t *testing.T) {
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	defer w.Close()
+	for i := 0; i < 3; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+
+	type progress struct{ segment, records int }
+	var got []progress
+	replayed := w.(*wrapper).metrics.replayProgress.WithLabelValues("", "")
+	require.NoError(t, w.(Extended).ReplayWithProgress(func(*wal.Record) error { return nil }, func(segment, records int) {
+		got = append(got, progress{segment, records})
+		require.Equal(t, float64(len(got)), testutil.ToFloat64(replayed))
+	}))
+	// each Log writes series and entries as separate records, and the last segment is empty
+	require.Equal(t, []progress{{0, 2}, {1, 4}, {2, 6}, {3, 6}}, got)
+	require.Equal(t, float64(4), testutil.ToFloat64(replayed))
+}
+
+func TestWAL_ReplaySeriesAndEntriesOnlyRecords(t *testing.T) {
+	var logs bytes.Buffer
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewLogfmtLogger(&logs), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	seriesOnly := func(ref uint64) *wal.Record {
+		rec := newTestRecord(ref)
+		rec.RefEntries = nil
+		return rec
+	}
+	entriesOnly := func(ref uint64, line string) *wal.Record {
+		rec := newTestRecord(ref, line)
+		rec.Series = nil
+		return rec
+	}
+	requireLog(t, w, seriesOnly(1))
+	requireLog(t, w, seriesOnly(2))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, w, entriesOnly(1, "first"))
+	requireLog(t, w, newTestRecord(3, "second"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, w, entriesOnly(2, "third"))
+	requireLog(t, w, entriesOnly(9, "orphan"))
+	requireLog(t, w, entriesOnly(9, "another orphan"))
+	require.NoError(t, w.Close())
+
+	labelsByRef := map[chunks.HeadSeriesRef]string{}
+	var lines []string
+	require.NoError(t, w.Replay(func(rec *wal.Record) error {
+		for _, s := range rec.Series {
+			labelsByRef[s.Ref] = s.Labels.String()
+		}
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, fmt.Sprintf("%s %s", labelsByRef[entries.Ref], e.Line))
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{
+		`{test="series-1"} first`,
+		`{test="series-3"} second`,
+		`{test="series-2"} third`,
+		` orphan`,
+		` another orphan`,
+	}, lines)
+	// entries referencing an unknown series are warned about once per series
+	require.Equal(t, 1, strings.Count(logs.String(), "unknown series"))
+	require.Contains(t, logs.String(), "ref=9")
+}
+
+func TestWAL_ReplayMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	defer w.Close()
+	metrics := w.(*wrapper).metrics
+
+	// replaying an empty WAL doesn't observe its duration
+	_, err = replayLines(w)
+	require.NoError(t, err)
+	require.Zero(t, testutil.CollectAndCount(metrics.replayDuration))
+	require.Zero(t, testutil.ToFloat64(metrics.replayedRecords.WithLabelValues("", "")))
+
+	for i := 0; i < 3; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	var records int
+	require.NoError(t, w.Replay(func(*wal.Record) error {
+		records++
+		return nil
+	}))
+	require.Equal(t, 6, records)
+	require.Equal(t, float64(records), testutil.ToFloat64(metrics.replayedRecords.WithLabelValues("", "")))
+	require.Equal(t, uint64(1), histogramSampleCount(t, reg, "promtail_wal_replay_duration_seconds"))
+
+	// records drained are counted as replayed too
+	require.NoError(t, w.(Extended).Drain(func(*wal.Record) error { return nil }))
+	require.Equal(t, float64(2*records), testutil.ToFloat64(metrics.replayedRecords.WithLabelValues("", "")))
+	require.Equal(t, uint64(2), histogramSampleCount(t, reg, "promtail_wal_replay_duration_seconds"))
+}
+
+// histogramSampleCount returns the number of samples observed by the histogram named name in reg, which must have a
+// single series.
+func histogramSampleCount(t *testing.T, reg *prometheus.Registry, name string) uint64 {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			require.Len(t, family.GetMetric(), 1)
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	t.Fatalf("histogram %s not found", name)
+	return 0
+}
+
+func TestWAL_ReplaySkipsStaleRecords(t *testing.T) {
+	clk := newFakeClock()
+	reg := prometheus.NewRegistry()
+	w, err := newWAL(log.NewNopLogger(), reg, Config{Enabled: true, Dir: t.TempDir(), MaxRecordAge: time.Hour}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+
+	aged := func(ref uint64, line string, ages ...time.Duration) *wal.Record {
+		rec := newTestRecord(ref)
+		rec.RefEntries[0].Entries = nil
+		for _, age := range ages {
+			rec.RefEntries[0].Entries = append(rec.RefEntries[0].Entries, logproto.Entry{Timestamp: clk.Now().Add(-age), Line: line})
+		}
+		return rec
+	}
+	requireLog(t, w, aged(1, "fresh", time.Minute))
+	requireLog(t, w, aged(2, "stale", 2*time.Hour))
+	// the newest entry decides
+	requireLog(t, w, aged(3, "mixed", 2*time.Hour, 30*time.Minute))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, w, aged(4, "stale", 3*time.Hour, 90*time.Minute))
+
+	skipped := w.(*wrapper).metrics.staleSkipped.WithLabelValues("", "")
+	var series int
+	var lines []string
+	require.NoError(t, w.Replay(func(rec *wal.Record) error {
+		series += len(rec.Series)
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"fresh", "mixed", "mixed"}, lines)
+	// series records are replayed even if all their entries are stale
+	require.Equal(t, 4, series)
+	require.Equal(t, float64(2), testutil.ToFloat64(skipped))
+
+	// stale records are skipped, and their segments removed, when draining too
+	clk.Advance(45 * time.Minute)
+	lines = nil
+	require.NoError(t, w.(Extended).Drain(func(rec *wal.Record) error {
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"fresh"}, lines)
+	require.Equal(t, float64(5), testutil.ToFloat64(skipped))
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Empty(t, lines)
+}
@@ -0,0 +1,144 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// evictOverLimits deletes the oldest segments in the WAL until its total size is under the configured max size, and
+// its segment count within the configured max segments. The segment currently being written to is never evicted, so
+// the WAL can still exceed the max size if that segment alone does.
+func (w *wrapper) evictOverLimits() error {
+	defer w.notifySegmentsDeleted()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.evictOverLimitsLocked()
+}
+
+// evictOverLimitsLocked is evictOverLimits, without notifying the evicted segments. Must be called with mtx held.
+func (w *wrapper) evictOverLimitsLocked() error {
+	// segments missing from a cached listing are evicted by a later call
+	segments, err := w.cachedSegmentRefs()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, segment := range segments {
+		total += segment.size
+	}
+	overSize := func() bool { return w.cfg.MaxSize > 0 && total > w.cfg.MaxSize }
+	overCount := func(evicted int) bool { return w.cfg.MaxSegments > 0 && len(segments)-evicted > w.cfg.MaxSegments }
+	// all segments but the last one, which is the head, can be evicted, oldest first
+	var evicted int
+	for ; evicted < len(segments)-1 && (overSize() || overCount(evicted)); evicted++ {
+		segment := segments[evicted]
+		if err := w.evictSegment(segment); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error evicting segment %d: %w", segment.number, err)
+		}
+		total -= segment.size
+		level.Info(w.log).Log("msg", "evicted WAL segment over the max size or max segments", "segment", segment.number,
+			"size", segment.size, "totalSize", total, "segments", len(segments)-evicted-1)
+		w.metrics.segmentsEvicted.WithLabelValues(w.clientName, w.tenantID).Inc()
+	}
+	if evicted == 0 {
+		return nil
+	}
+	return w.dirChanged()
+}
+
+// Pressure returns the ratio of the WAL size to Config.MaxSize, which producers can poll to slow down before the oldest
+// segments get evicted. It's 0 if no max size is configured, and it can exceed 1 while the head segment alone is over
+// the max size. Since it reads the WAL directory, errors doing so are logged, returning 0.
+func (w *wrapper) Pressure() float64 {
+	if w.cfg.MaxSize == 0 {
+		return 0
+	}
+	size, err := w.Size()
+	if err != nil {
+		level.Warn(w.log).Log("msg", "failed to compute WAL pressure", "err", err)
+		return 0
+	}
+	return float64(size) / float64(w.cfg.MaxSize)
+}
+
+// IsFull returns true if the WAL has reached Config.MaxSize, so that the next write evicts segments.
+func (w *wrapper) IsFull() bool {
+	return w.Pressure() >= 1
+}
+
+// DeleteOlderThan removes all segments whose files were last modified more than d ago, returning how many were removed.
+// The segment currently being written to is never removed. Removed segments are archived first if Config.ArchiveDir
+// is set.
+func (w *wrapper) DeleteOlderThan(d time.Duration) (int, error) {
+	if err := w.checkWrite(); err != nil {
+		return 0, err
+	}
+	defer w.notifySegmentsDeleted()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return 0, err
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return 0, err
+	}
+	var deleted int
+	cutoff := w.clock.Now().Add(-d)
+	// skip the last segment, which is the head
+	for i := 0; i < len(segments)-1; i++ {
+		segment := segments[i]
+		if !segment.lastModified.Before(cutoff) {
+			continue
+		}
+		if err := w.evictSegment(segment); err != nil && !os.IsNotExist(err) {
+			return deleted, fmt.Errorf("error removing segment %d: %w", segment.number, err)
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	return deleted, w.dirChanged()
+}
+
+// notifySegmentsDeleted calls Config.OnSegmentDelete for each segment removed since the last call. It must be called
+// without holding mtx, so that the hook can safely call back into the WAL. Removing methods defer it before locking.
+func (w *wrapper) notifySegmentsDeleted() {
+	if w.cfg.OnSegmentDelete == nil {
+		return
+	}
+	w.mtx.Lock()
+	deleted := w.deletedSegments
+	w.deletedSegments = nil
+	w.mtx.Unlock()
+	for _, segment := range deleted {
+		w.cfg.OnSegmentDelete(segment)
+	}
+}
+
+// segmentCreated queues segmentNum, just created by rotating the WAL, to be notified to Config.OnSegmentCreate. Must
+// be called with mtx held.
+func (w *wrapper) segmentCreated(segmentNum int) {
+	if w.cfg.OnSegmentCreate != nil {
+		w.createdSegments = append(w.createdSegments, segmentNum)
+	}
+}
+
+// notifySegmentsCreated calls Config.OnSegmentCreate for each segment created since the last call. As with
+// notifySegmentsDeleted, it must be called without holding mtx, and rotating methods defer it before locking.
+func (w *wrapper) notifySegmentsCreated() {
+	if w.cfg.OnSegmentCreate == nil {
+		return
+	}
+	w.mtx.Lock()
+	created := w.createdSegments
+	w.createdSegments = nil
+	w.mtx.Unlock()
+	for _, segment := range created {
+		w.cfg.OnSegmentCreate(segment)
+	}
+}
@@ -0,0 +1,234 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+func TestWAL_MaxSizeEviction(t *testing.T) {
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:     true,
+		Dir:         t.TempDir(),
+		SegmentSize: minSegmentSize,
+		MaxSize:     2 * minSegmentSize,
+	}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+
+	// write ~5MB, which would take at least 5 segments without eviction
+	line := strings.Repeat("a", 1024)
+	for i := 0; i < 5000; i++ {
+		requireLog(t, w, newTestRecord(1, line))
+	}
+
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.LessOrEqual(t, size, int64(2*minSegmentSize))
+
+	// oldest segments are evicted first, keeping the most recent ones
+	segments := segmentNumbers(t, w.Dir())
+	require.GreaterOrEqual(t, segments[0], 3)
+	current, err := w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, current, segments[len(segments)-1])
+	evicted := w.(*wrapper).metrics.segmentsEvicted.WithLabelValues("client", "tenant")
+	require.Equal(t, float64(segments[0]), testutil.ToFloat64(evicted))
+}
+
+func TestWAL_MaxSizeEvictionKeepsActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, MaxSize: 1024}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		requireLog(t, w, newTestRecord(1, strings.Repeat("a", 512)))
+	}
+	require.Equal(t, []int{0}, segmentNumbers(t, dir))
+}
+
+func TestWAL_MaxSegmentsEviction(t *testing.T) {
+	var deleted []int
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:         true,
+		Dir:             t.TempDir(),
+		MaxSegments:     3,
+		OnSegmentDelete: func(segmentNum int) { deleted = append(deleted, segmentNum) },
+	}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+	evicted := w.(*wrapper).metrics.segmentsEvicted.WithLabelValues("client", "tenant")
+
+	for i := 0; i < 2; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{0, 1, 2}, segmentNumbers(t, w.Dir()))
+	require.Zero(t, testutil.ToFloat64(evicted))
+
+	// oldest segments are evicted first as new ones are created
+	for i := 2; i < 5; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{3, 4, 5}, segmentNumbers(t, w.Dir()))
+	require.Equal(t, []int{0, 1, 2}, deleted)
+	require.Equal(t, float64(3), testutil.ToFloat64(evicted))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 3", "line 4"}, lines)
+}
+
+func TestWAL_MaxSegmentsEvictionKeepsActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, MaxSegments: 1}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	next, err := w.NextSegment()
+	require.NoError(t, err)
+	require.Equal(t, []int{next}, segmentNumbers(t, dir))
+	requireLog(t, w, newTestRecord(2, "second"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"second"}, lines)
+}
+
+func TestWAL_DeleteOlderThan(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 4)
+	defer w.Close()
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, segment := range []int{0, 1, 3} {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, fmt.Sprintf("%08d", segment)), old, old))
+	}
+
+	deleted, err := w.DeleteOlderThan(time.Hour)
+	require.NoError(t, err)
+	// segment 3 is the head, so it's kept even though it's old
+	require.Equal(t, 2, deleted)
+	require.Equal(t, []int{2, 3}, segmentNumbers(t, dir))
+
+	deleted, err = w.DeleteOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+
+	deleted, err = noopWAL{}.DeleteOlderThan(time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+}
+
+func TestWAL_OnSegmentDelete(t *testing.T) {
+	var deleted []int
+	var w WAL
+	w, err := New(Config{
+		Enabled: true,
+		Dir:     t.TempDir(),
+		OnSegmentDelete: func(segmentNum int) {
+			// calling back into the WAL must not deadlock
+			_, err := w.CountSegments()
+			require.NoError(t, err)
+			deleted = append(deleted, segmentNum)
+		},
+	}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, w.DeleteSegment(1))
+	require.Equal(t, []int{1}, deleted)
+	require.Error(t, w.DeleteSegment(1))
+	require.Equal(t, []int{1}, deleted)
+
+	require.NoError(t, w.Truncate(4))
+	require.Equal(t, []int{1, 0, 2, 3}, deleted)
+}
+
+func TestWAL_OnSegmentCreate(t *testing.T) {
+	var created []int
+	var w WAL
+	w, err := New(Config{
+		Enabled: true,
+		Dir:     t.TempDir(),
+		OnSegmentCreate: func(segmentNum int) {
+			// calling back into the WAL must not deadlock
+			current, err := w.CurrentSegment()
+			require.NoError(t, err)
+			require.Equal(t, current, segmentNum)
+			created = append(created, segmentNum)
+		},
+	}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var want []int
+	for i := 0; i < 3; i++ {
+		segment, err := w.NextSegment()
+		require.NoError(t, err)
+		want = append(want, segment)
+	}
+	require.Equal(t, []int{1, 2, 3}, want)
+	segment, err := w.(Extended).Rotate()
+	require.NoError(t, err)
+	want = append(want, segment)
+	require.Equal(t, want, created)
+
+	// draining an empty head segment doesn't rotate
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.NoError(t, w.(Extended).Drain(func(*wal.Record) error { return nil }))
+	require.NoError(t, w.(Extended).Drain(func(*wal.Record) error { return nil }))
+	require.Equal(t, append(want, 5), created)
+}
+
+func TestWAL_Pressure(t *testing.T) {
+	const maxSize = 1024 * 1024
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), MaxSize: maxSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+	require.InDelta(t, float64(versionRecordSize)/maxSize, ww.Pressure(), 1e-9)
+
+	line := strings.Repeat("a", 8*1024)
+	for {
+		size, err := w.Size()
+		require.NoError(t, err)
+		if size >= maxSize*8/10 {
+			break
+		}
+		requireLog(t, w, newTestRecord(1, line))
+	}
+	require.InDelta(t, 0.8, ww.Pressure(), 0.01)
+	require.False(t, ww.IsFull())
+
+	for !ww.IsFull() {
+		requireLog(t, w, newTestRecord(1, line))
+	}
+	require.GreaterOrEqual(t, ww.Pressure(), 1.0)
+
+	t.Run("no max size", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		requireLog(t, w, newTestRecord(1, line))
+		require.Zero(t, w.(Extended).Pressure())
+		require.False(t, w.(Extended).IsFull())
+	})
+}
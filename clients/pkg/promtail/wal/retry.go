@@ -0,0 +1,101 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+)
+
+const (
+	// defaultWriteRetryBackoff is used when Config.WriteRetryBackoff is not set.
+	defaultWriteRetryBackoff = 10 * time.Millisecond
+	// maxWriteRetryBackoff caps the wait between write retries, unless Config.WriteRetryBackoff is larger.
+	maxWriteRetryBackoff = time.Second
+)
+
+// retryableWriteError returns whether err, returned writing to the underlying WAL, may clear by itself, so that the
+// write can be retried.
+func retryableWriteError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// logToWAL writes recs to the underlying WAL in a single attempt, marking errors caused by a full disk as ErrDiskFull.
+// Failed writes are retried by retryWrites, once the WAL locks are released.
+func (w *wrapper) logToWAL(recs ...[]byte) error {
+	return asDiskFull(w.wal.Log(recs...))
+}
+
+// pendingFlushError is returned when records were appended to the buffer, but flushing it failed with a retryable
+// error, so that the records are kept buffered, and retrying means flushing again rather than appending them twice.
+type pendingFlushError struct {
+	err error
+}
+
+func (e pendingFlushError) Error() string {
+	return e.err.Error()
+}
+
+func (e pendingFlushError) Unwrap() error {
+	return e.err
+}
+
+// pendingFlush returns err, returned flushing the buffer after appending records to it, as a pendingFlushError if it's
+// going to be retried, so that the records are kept buffered.
+func (w *wrapper) pendingFlush(err error) error {
+	if err != nil && w.cfg.WriteRetries > 0 && retryableWriteError(err) {
+		return pendingFlushError{err: err}
+	}
+	return err
+}
+
+// resumingFlush wraps write so that, once it fails with a pendingFlushError, later calls resume it by calling resume,
+// which flushes the buffer, instead of appending the records to it again, until that succeeds.
+func resumingFlush(write, resume func() error) func() error {
+	var pending bool
+	return func() error {
+		var err error
+		if pending {
+			err = resume()
+		} else {
+			err = write()
+		}
+		pending = err != nil && (pending || errors.As(err, &pendingFlushError{}))
+		return err
+	}
+}
+
+// retryWrites calls write, which must not hold mtx, retrying it up to Config.WriteRetries times with an exponential
+// backoff if it fails with a retryable error. The backoff is waited through the WAL clock without holding any lock, so
+// that other operations aren't blocked meanwhile.
+func (w *wrapper) retryWrites(write func() error) error {
+	err := write()
+	if err == nil || w.cfg.WriteRetries == 0 || !retryableWriteError(err) {
+		return err
+	}
+	minBackoff := w.cfg.writeRetryBackoff()
+	maxBackoff := maxWriteRetryBackoff
+	if minBackoff > maxBackoff {
+		maxBackoff = minBackoff
+	}
+	b := backoff.New(context.Background(), backoff.Config{MinBackoff: minBackoff, MaxBackoff: maxBackoff})
+	for retry := 1; retry <= w.cfg.WriteRetries; retry++ {
+		delay := b.NextDelay()
+		level.Warn(w.log).Log("msg", "failed to write to WAL, retrying", "err", err, "retry", retry, "delay", delay)
+		sleep(w.clock, delay)
+		if err = write(); err == nil || !retryableWriteError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// sleep waits for d to pass on c.
+func sleep(c clock, d time.Duration) {
+	t := c.NewTicker(d)
+	defer t.Stop()
+	<-t.C()
+}
@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyWL fails the first failures writes with err, then writes to the wrapped writeLog.
+type flakyWL struct {
+	writeLog
+	err      error
+	failures int
+	attempts int
+}
+
+func (f *flakyWL) Log(recs ...[]byte) error {
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	return f.writeLog.Log(recs...)
+}
+
+func TestWAL_WriteRetries(t *testing.T) {
+	diskFull := &os.PathError{Op: "write", Path: "00000000", Err: syscall.ENOSPC}
+	for name, tc := range map[string]struct {
+		retries  int
+		err      error
+		failures int
+		attempts int
+		fails    bool
+	}{
+		"retryable error clearing": {
+			retries:  3,
+			err:      diskFull,
+			failures: 3,
+			attempts: 4,
+		},
+		"retryable error not clearing": {
+			retries:  2,
+			err:      diskFull,
+			failures: 3,
+			attempts: 3,
+			fails:    true,
+		},
+		"interrupted write": {
+			retries:  1,
+			err:      syscall.EINTR,
+			failures: 1,
+			attempts: 2,
+		},
+		"retries disabled": {
+			err:      diskFull,
+			failures: 1,
+			attempts: 1,
+			fails:    true,
+		},
+		"non retryable error": {
+			retries:  3,
+			err:      os.ErrPermission,
+			failures: 1,
+			attempts: 1,
+			fails:    true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w, err := New(Config{
+				Enabled:           true,
+				Dir:               t.TempDir(),
+				WriteRetries:      tc.retries,
+				WriteRetryBackoff: time.Millisecond,
+			}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			defer w.Close()
+			flaky := injectWL(w, func(wl writeLog) *flakyWL {
+				return &flakyWL{writeLog: wl, err: tc.err, failures: tc.failures}
+			})
+
+			// records with only entries are written in a single WAL operation
+			rec := newTestRecord(1, "line")
+			rec.Series = nil
+			_, err = w.Log(rec)
+			require.Equal(t, tc.attempts, flaky.attempts)
+			if tc.fails {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			lines, err := replayLines(w)
+			require.NoError(t, err)
+			require.Equal(t, []string{"line"}, lines)
+		})
+	}
+
+	t.Run("record too large", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: minSegmentSize, WriteRetries: 3}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		flaky := injectWL(w, func(wl writeLog) *flakyWL { return &flakyWL{writeLog: wl} })
+		_, err = w.Log(newTestRecord(1, string(make([]byte, minSegmentSize))))
+		require.ErrorIs(t, err, ErrRecordTooLarge)
+		require.Zero(t, flaky.attempts)
+	})
+}
+
+func TestWAL_WriteRetriesDontHoldLock(t *testing.T) {
+	for name, bufferSize := range map[string]int{"unbuffered": 0, "buffered": 1} {
+		t.Run(name, func(t *testing.T) {
+			clk := newFakeClock()
+			w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+				Enabled:      true,
+				Dir:          t.TempDir(),
+				WriteRetries: 1,
+				BufferSize:   bufferSize,
+			}, "", "", withClock(clk))
+			require.NoError(t, err)
+			defer w.Close()
+			flaky := injectWL(w, func(wl writeLog) *flakyWL {
+				return &flakyWL{writeLog: wl, err: syscall.EINTR, failures: 1}
+			})
+
+			done := make(chan error)
+			go func() {
+				_, err := w.(Extended).LogTracked(newTestRecord(1, "line"))
+				done <- err
+			}()
+			// wait for the backoff to start
+			require.Eventually(t, func() bool {
+				clk.mtx.Lock()
+				defer clk.mtx.Unlock()
+				return len(clk.tickers) > 0
+			}, time.Second, time.Millisecond)
+
+			// other operations, taking the WAL lock, go on meanwhile
+			_, err = w.CurrentSegment()
+			require.NoError(t, err)
+			_, err = w.Size()
+			require.NoError(t, err)
+			select {
+			case err := <-done:
+				t.Fatalf("write returned before the backoff passed: %v", err)
+			default:
+			}
+
+			// the first backoff is jittered up to twice the configured one
+			clk.Advance(2 * defaultWriteRetryBackoff)
+			require.NoError(t, <-done)
+			require.Equal(t, 2, flaky.attempts)
+			require.NoError(t, w.Close())
+			// the record is written once, even if buffered
+			lines, err := replayLines(w)
+			require.NoError(t, err)
+			require.Equal(t, []string{"line"}, lines)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// segmentAgeCollector exposes promtail_wal_oldest_segment_age_seconds for every open WAL created over the same
+// registerer, computed when scraped by reading the WAL directory, so that the age keeps growing while delivery is
+// stuck, even if nothing is logged. Closed WALs aren't exposed.
+type segmentAgeCollector struct {
+	desc *prometheus.Desc
+
+	mtx sync.Mutex
+	// wals maps each open WAL to its directory.
+	wals map[*wrapper]string
+}
+
+func newSegmentAgeCollector() *segmentAgeCollector {
+	return &segmentAgeCollector{
+		desc: prometheus.NewDesc(
+			"promtail_wal_oldest_segment_age_seconds",
+			"Time since the oldest WAL segment not yet checkpointed was last modified, or 0 if there's none.",
+			[]string{"client", "tenant"},
+			nil,
+		),
+		wals: map[*wrapper]string{},
+	}
+}
+
+func (c *segmentAgeCollector) add(w *wrapper, dir string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.wals[w] = dir
+}
+
+func (c *segmentAgeCollector) remove(w *wrapper) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.wals, w)
+}
+
+func (c *segmentAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *segmentAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	wals := make(map[*wrapper]string, len(c.wals))
+	for w, dir := range c.wals {
+		wals[w] = dir
+	}
+	c.mtx.Unlock()
+
+	// WALs of the same client and tenant in different directories are exposed as the oldest of them
+	type key struct{ client, tenant string }
+	ages := map[key]time.Duration{}
+	for w, dir := range wals {
+		age, err := w.oldestSegmentAge(dir)
+		if err != nil {
+			level.Warn(w.log).Log("msg", "failed to compute WAL oldest segment age", "err", err)
+			continue
+		}
+		k := key{w.clientName, w.tenantID}
+		if prev, ok := ages[k]; !ok || age > prev {
+			ages[k] = age
+		}
+	}
+	for k, age := range ages {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age.Seconds(), k.client, k.tenant)
+	}
+}
+
+// oldestSegmentAge returns the time since the lowest numbered segment in dir not before the checkpoint, that is, the
+// oldest one still to be delivered, was last modified, or 0 if there's none. It only reads dir, without taking mtx, so
+// that scrapes aren't held back by long operations such as Drain.
+func (w *wrapper) oldestSegmentAge(dir string) (time.Duration, error) {
+	segments, err := readNamedSegmentRefs(w.fs, dir, w.cfg.segmentNumber)
+	if err != nil {
+		return 0, err
+	}
+	checkpoint, err := readCheckpointFile(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range segments {
+		if segment.number < checkpoint {
+			continue
+		}
+		if age := w.clock.Now().Sub(segment.lastModified); age > 0 {
+			return age, nil
+		}
+		return 0, nil
+	}
+	return 0, nil
+}
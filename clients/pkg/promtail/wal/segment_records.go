@@ -0,0 +1,63 @@
+package wal
+
+import (
+	"fmt"
+
+	"github.com/go-kit/log/level"
+)
+
+// segmentRecordCount caches the number of records in a closed segment, along with the segment size it was counted at.
+type segmentRecordCount struct {
+	size    int64
+	records int
+}
+
+// segmentRecords returns the number of records in each of the given segments, keyed by segment number. Records are
+// counted by scanning the record headers of each segment, without decoding them, since the writes of wlog can't be
+// attributed to a segment as they happen, wlog rotating on its own when a segment fills up. Closed segments don't
+// change, so their counts are cached and only the head segment is scanned on every call, keeping repeated calls cheap.
+// Segments that can't be fully read are counted up to the first unreadable record. Must be called with mtx held for
+// writing.
+func (w *wrapper) segmentRecords(segments []segmentRef) map[int]int {
+	counts := make(map[int]int, len(segments))
+	cached := make(map[int]segmentRecordCount, len(segments))
+	for i, segment := range segments {
+		head := i == len(segments)-1
+		if c, ok := w.recordCounts[segment.number]; ok && !head && c.size == segment.size {
+			counts[segment.number] = c.records
+			cached[segment.number] = c
+			continue
+		}
+		records, err := w.countSegmentRecords(segment.number)
+		if err != nil {
+			level.Warn(w.log).Log("msg", "failed to count records in WAL segment", "segment", segment.number, "err", err)
+		} else if !head {
+			cached[segment.number] = segmentRecordCount{size: segment.size, records: records}
+		}
+		counts[segment.number] = records
+	}
+	// dropping the counts of the segments not found anymore
+	w.recordCounts = cached
+	return counts
+}
+
+// countSegmentRecords counts the records in the given segment, not including the version header.
+func (w *wrapper) countSegmentRecords(segmentNum int) (int, error) {
+	segment, reader, err := openSegment(w.segmentPath(segmentNum))
+	if err != nil {
+		return 0, err
+	}
+	defer segment.Close()
+
+	var records int
+	for reader.Next() {
+		if isVersion, _ := checkVersionRecord(reader.Record()); isVersion {
+			continue
+		}
+		records++
+	}
+	if err := reader.Err(); err != nil {
+		return records, fmt.Errorf("error reading wal at offset %d: %w", reader.Offset(), err)
+	}
+	return records, nil
+}
@@ -0,0 +1,45 @@
+package wal
+
+import (
+	"sync"
+	"time"
+)
+
+// statCache holds a snapshot of the WAL directory listing, reused by size and count queries while younger than
+// Config.StatCacheTTL.
+type statCache struct {
+	mtx   sync.Mutex
+	refs  []segmentRef
+	read  time.Time
+	valid bool
+}
+
+// cachedSegmentRefs is segmentRefs, but returns the last listing read if it's younger than Config.StatCacheTTL. The
+// listing is stale-but-valid: segment sizes and modtimes may have changed since, and segments created by wlog rotating
+// on its own may be missing, so it must only back queries that tolerate that, never writes. Must be called with mtx
+// held, either for reading or writing.
+func (w *wrapper) cachedSegmentRefs() ([]segmentRef, error) {
+	if w.cfg.StatCacheTTL <= 0 {
+		return w.segmentRefs()
+	}
+	w.statCache.mtx.Lock()
+	defer w.statCache.mtx.Unlock()
+	now := w.clock.Now()
+	if !w.statCache.valid || now.Sub(w.statCache.read) >= w.cfg.StatCacheTTL {
+		refs, err := w.segmentRefs()
+		if err != nil {
+			return nil, err
+		}
+		w.statCache.refs, w.statCache.read, w.statCache.valid = refs, now, true
+	}
+	// callers may modify the returned slice
+	return append([]segmentRef(nil), w.statCache.refs...), nil
+}
+
+// invalidateStatCache drops the cached directory listing, so that the next query reads the directory again. It's
+// called whenever segments are created or removed.
+func (w *wrapper) invalidateStatCache() {
+	w.statCache.mtx.Lock()
+	defer w.statCache.mtx.Unlock()
+	w.statCache.refs, w.statCache.valid = nil, false
+}
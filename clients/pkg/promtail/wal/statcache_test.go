@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_StatCache(t *testing.T) {
+	clk := newFakeClock()
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:      true,
+		Dir:          t.TempDir(),
+		StatCacheTTL: time.Minute,
+	}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	require.NoError(t, w.Sync())
+	size, err := w.Size()
+	require.NoError(t, err)
+
+	// within the TTL, the last snapshot is served even though the head grew
+	requireLog(t, w, newTestRecord(1, "second"))
+	require.NoError(t, w.Sync())
+	clk.Advance(59 * time.Second)
+	cached, err := w.Size()
+	require.NoError(t, err)
+	require.Equal(t, size, cached)
+
+	clk.Advance(time.Second)
+	refreshed, err := w.Size()
+	require.NoError(t, err)
+	require.Greater(t, refreshed, size)
+
+	// creating or removing segments refreshes the snapshot right away
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	count, err := w.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	require.NoError(t, w.DeleteSegment(0))
+	segments, err := w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, segments)
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Segments)
+}
+
+func TestWAL_StatCacheDisabled(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "first"))
+	require.NoError(t, w.Sync())
+	size, err := w.Size()
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "second"))
+	require.NoError(t, w.Sync())
+	grown, err := w.Size()
+	require.NoError(t, err)
+	require.Greater(t, grown, size)
+}
@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Syncer syncs many WALs running in SyncModeInterval from a single goroutine, instead of each of them running its own,
+// which matters when there are thousands of tenant WALs. WALs are registered when created with WithSyncer, or by a
+// Manager, and deregistered when closed. On every tick, all registered WALs are synced one after the other, each tick
+// starting from the WAL after the one that started the previous tick, so that a slow sync doesn't always delay the same
+// WALs. The interval of the Syncer applies instead of the Config.SyncInterval of each WAL.
+type Syncer struct {
+	log log.Logger
+
+	mtx  sync.Mutex
+	wals []*wrapper
+	// next is the index in wals the next round of syncs starts from.
+	next int
+
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewSyncer creates a Syncer syncing the WALs registered with it every interval, until stopped.
+func NewSyncer(log log.Logger, interval time.Duration) *Syncer {
+	return newSyncer(log, realClock{}.NewTicker(interval))
+}
+
+func newSyncer(logger log.Logger, ticker ticker) *Syncer {
+	s := &Syncer{
+		log:  logger,
+		quit: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run(ticker)
+	return s
+}
+
+func (s *Syncer) run(ticker ticker) {
+	defer s.wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			s.syncAll()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// syncAll syncs every registered WAL once, round-robin.
+func (s *Syncer) syncAll() {
+	s.mtx.Lock()
+	wals := make([]*wrapper, 0, len(s.wals))
+	if len(s.wals) > 0 {
+		start := s.next % len(s.wals)
+		wals = append(append(wals, s.wals[start:]...), s.wals[:start]...)
+		s.next = start + 1
+	}
+	s.mtx.Unlock()
+	for _, w := range wals {
+		// a WAL closed since the list was copied is synced by its own shutdown
+		if err := w.Sync(); err != nil && !errors.Is(err, ErrClosed) {
+			level.Error(w.log).Log("msg", "failed to sync WAL", "err", err)
+		}
+	}
+}
+
+// register adds w to the WALs synced on every tick.
+func (s *Syncer) register(w *wrapper) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.wals = append(s.wals, w)
+}
+
+// deregister removes w from the WALs synced on every tick. Deregistering a WAL not registered is a no-op.
+func (s *Syncer) deregister(w *wrapper) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i, registered := range s.wals {
+		if registered == w {
+			s.wals = append(s.wals[:i], s.wals[i+1:]...)
+			return
+		}
+	}
+}
+
+// Stop stops syncing the registered WALs, waiting for an ongoing round of syncs to finish. WALs still registered aren't
+// closed, nor synced anymore. Calling Stop more than once is a no-op.
+func (s *Syncer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.wg.Wait()
+	})
+}
@@ -0,0 +1,92 @@
+package wal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// registeredWALs returns the number of WALs registered with s.
+func registeredWALs(s *Syncer) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.wals)
+}
+
+func TestSyncer(t *testing.T) {
+	clk := newFakeClock()
+	s := newSyncer(log.NewNopLogger(), clk.NewTicker(time.Minute))
+	defer s.Stop()
+
+	var wals []WAL
+	var recs []*recordingWL
+	for i := 0; i < 10; i++ {
+		w, err := newWAL(log.NewNopLogger(), nil, Config{
+			Enabled:      true,
+			Dir:          t.TempDir(),
+			SyncMode:     SyncModeInterval,
+			SyncInterval: time.Hour,
+		}, "client", fmt.Sprintf("tenant-%d", i), withClock(clk), withSyncer(s))
+		require.NoError(t, err)
+		defer w.Close()
+		wals = append(wals, w)
+		recs = append(recs, injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} }))
+	}
+	// the WALs don't start routines, nor tickers, of their own
+	clk.mtx.Lock()
+	require.Len(t, clk.tickers, 1)
+	clk.mtx.Unlock()
+	require.Equal(t, 10, registeredWALs(s))
+
+	synced := func(rec *recordingWL, n int) func() bool {
+		return func() bool { return rec.count("sync") == n }
+	}
+	clk.Advance(time.Minute)
+	for _, rec := range recs {
+		require.Eventually(t, synced(rec, 1), 5*time.Second, time.Millisecond)
+	}
+
+	// closing a WAL syncs it a last time, and deregisters it
+	require.NoError(t, wals[0].Close())
+	require.Equal(t, 2, recs[0].count("sync"))
+	require.Equal(t, 9, registeredWALs(s))
+	clk.Advance(time.Minute)
+	for _, rec := range recs[1:] {
+		require.Eventually(t, synced(rec, 2), 5*time.Second, time.Millisecond)
+	}
+	require.Equal(t, 2, recs[0].count("sync"))
+
+	// once stopped, registered WALs aren't synced anymore: Stop waits for the routine to exit, stopping its ticker, so
+	// that no tick is sent anymore
+	s.Stop()
+	s.Stop()
+	clk.mtx.Lock()
+	require.True(t, clk.tickers[0].stopped)
+	clk.mtx.Unlock()
+	clk.Advance(time.Minute)
+	require.Empty(t, clk.tickers[0].c)
+	require.Equal(t, 2, recs[1].count("sync"))
+}
+
+func TestManager_SharedSyncer(t *testing.T) {
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), Config{
+		Enabled:      true,
+		Dir:          t.TempDir(),
+		SyncMode:     SyncModeInterval,
+		SyncInterval: time.Minute,
+	}, "client")
+	require.NotNil(t, m.syncer)
+	require.NoError(t, m.Log("tenant-a", newTestRecord(1, "a")))
+	require.NoError(t, m.Log("tenant-b", newTestRecord(1, "b")))
+	require.Equal(t, 2, registeredWALs(m.syncer))
+
+	require.NoError(t, m.CloseAll())
+	require.Zero(t, registeredWALs(m.syncer))
+
+	// a manager not syncing on an interval doesn't need a syncer
+	require.Nil(t, NewManager(log.NewNopLogger(), nil, Config{Enabled: true, Dir: t.TempDir()}, "client").syncer)
+}
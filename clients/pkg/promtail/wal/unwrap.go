@@ -0,0 +1,29 @@
+package wal
+
+import "github.com/prometheus/prometheus/tsdb/wlog"
+
+// WLUnwrapper is implemented by the WALs of this package, giving direct access to the underlying wlog.WL for the
+// operations WAL doesn't cover.
+//
+// This is an escape hatch with no stability guarantee: the wlog.WL is an implementation detail, which may change
+// between versions, and which is replaced by Reset. Writing to it, rotating or closing it bypasses the wrapper's
+// locking, buffering, and metrics, and may leave the WAL inconsistent.
+type WLUnwrapper interface {
+	// Unwrap returns the underlying wlog.WL, or nil if there's none.
+	Unwrap() *wlog.WL
+}
+
+func (noopWAL) Unwrap() *wlog.WL { return nil }
+
+// Unwrap returns the wlog.WL currently written to, excluding the mirror if Config.MirrorDir is set, or nil if the WAL
+// was opened over something else, as tests do.
+func (w *wrapper) Unwrap() *wlog.WL {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	wl := w.wal
+	if mirrored, ok := wl.(*mirroredLog); ok {
+		wl = mirrored.writeLog
+	}
+	unwrapped, _ := wl.(*wlog.WL)
+	return unwrapped
+}
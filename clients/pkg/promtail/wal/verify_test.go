@@ -0,0 +1,37 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAL_Verify(t *testing.T) {
+	t.Run("clean", func(t *testing.T) {
+		w := newSegments(t, t.TempDir(), 3)
+		require.NoError(t, w.Close())
+
+		result, err := w.(Extended).Verify()
+		require.NoError(t, err)
+		// each Log writes series and entries as separate records
+		require.Equal(t, VerifyResult{Segments: 3, Records: 6}, result)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newCorruptedWAL(t, dir, true)
+		// a file named as a segment that isn't a WAL segment at all
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "00000002"), []byte("not a segment"), 0o644))
+
+		result, err := w.(Extended).Verify()
+		require.NoError(t, err)
+		require.Equal(t, 3, result.Segments)
+		require.Equal(t, []int{0, 2}, result.UnreadableSegments)
+		require.ErrorContains(t, result.FirstErr, "segment 0")
+		require.ErrorContains(t, result.LastErr, "segment 2")
+		// series and entries of "first" in segment 0, and of "third" in segment 1
+		require.Equal(t, 4, result.Records)
+	})
+}
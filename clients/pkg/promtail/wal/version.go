@@ -0,0 +1,120 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// walVersion is the version of the on-disk format written by this package. Headerless WALs, written before versions
+// were introduced, are read as version 0, which shares the same format.
+const walVersion = 1
+
+// ErrUnsupportedWALVersion is returned when reading a WAL written in a newer format than this package supports.
+var ErrUnsupportedWALVersion = errors.New("unsupported WAL version")
+
+// versionFileName is the name of the file under the WAL directory the format version is persisted in, holding the
+// same bytes as the version header record. The header only starts segment 0, which is eventually removed, while the
+// file outlives segment removals, so readers check it before reading each segment. Since it isn't a number, it's
+// never listed as a segment.
+const versionFileName = "version"
+
+// versionRecordMagic prefixes the version header record. The leading byte isn't a valid record type, and the record is
+// written as is, without checksums or custom encoding, so that it can be recognized before decoding anything.
+var versionRecordMagic = []byte("\xffpromtail-wal")
+
+// encodeVersionRecord returns the header record holding the given format version.
+func encodeVersionRecord(version byte) []byte {
+	return append(append([]byte{}, versionRecordMagic...), version)
+}
+
+// checkVersionRecord returns whether b is a version header record, and if so, an error wrapping
+// ErrUnsupportedWALVersion if the version it holds is newer than walVersion.
+func checkVersionRecord(b []byte) (bool, error) {
+	if len(b) != len(versionRecordMagic)+1 || !bytes.HasPrefix(b, versionRecordMagic) {
+		return false, nil
+	}
+	if version := b[len(b)-1]; version > walVersion {
+		return true, fmt.Errorf("%w: found version %d, but only up to %d is supported", ErrUnsupportedWALVersion, version, walVersion)
+	}
+	return true, nil
+}
+
+// writeVersionRecord writes the version header record as the first record of a fresh WAL, that is, one whose only
+// segment is empty. Since the header goes away with segment 0, the version is also persisted by writeVersionFile. Must
+// be called with mtx held.
+func (w *wrapper) writeVersionRecord() error {
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	if len(segments) != 1 || segments[0].size != 0 {
+		return nil
+	}
+	if err := w.wal.Log(encodeVersionRecord(walVersion)); err != nil {
+		return fmt.Errorf("failed to write WAL version: %w", err)
+	}
+	return nil
+}
+
+// headHoldsOnlyVersion returns whether the segment being written to, of the given size, holds nothing but the version
+// header record, so that there's nothing to drain from it. Must be called with mtx held.
+func (w *wrapper) headHoldsOnlyVersion(headSize int64) bool {
+	if headSize != int64(recordHeaderSize+len(versionRecordMagic)+1) {
+		return false
+	}
+	head, err := w.currentSegment()
+	if err != nil {
+		return false
+	}
+	records, err := w.countSegmentRecords(head)
+	return err == nil && records == 0
+}
+
+// writeVersionFile persists walVersion in the version file of dir, unless there's one already, which may hold a newer
+// version that must be kept. WALs written before the file was introduced share the current format, so it's written for
+// them too.
+func writeVersionFile(dir string) error {
+	path := filepath.Join(dir, versionFileName)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".version-")
+	if err != nil {
+		return fmt.Errorf("failed to write WAL version: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(encodeVersionRecord(walVersion))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write WAL version: %w", err)
+	}
+	return nil
+}
+
+// checkVersionFile returns an error wrapping ErrUnsupportedWALVersion if the version file of dir holds a version newer
+// than walVersion. A missing file, as in WALs written before it was introduced, isn't an error.
+func checkVersionFile(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, versionFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading WAL version: %w", err)
+	}
+	isVersion, err := checkVersionRecord(data)
+	if !isVersion {
+		return fmt.Errorf("invalid WAL version file %q", data)
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// versionRecordSize is the size taken in a segment by the version header record.
+var versionRecordSize = recordHeaderSize + len(versionRecordMagic) + 1
+
+// writeRawWAL writes header, if not nil, followed by lines to a WAL in dir using wlog directly.
+func writeRawWAL(t *testing.T, dir string, header []byte, lines ...string) {
+	wl, err := wlog.New(log.NewNopLogger(), nil, dir, false)
+	require.NoError(t, err)
+	if header != nil {
+		require.NoError(t, wl.Log(header))
+	}
+	for i, line := range lines {
+		bufs, err := EncodeRecord(newTestRecord(uint64(i), line))
+		require.NoError(t, err)
+		require.NoError(t, wl.Log(bufs...))
+	}
+	require.NoError(t, wl.Close())
+}
+
+func TestWAL_VersionHeader(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.NoError(t, w.Close())
+
+	r, err := wlog.NewSegmentsReader(dir)
+	require.NoError(t, err)
+	reader := wlog.NewReader(r)
+	require.True(t, reader.Next())
+	require.Equal(t, encodeVersionRecord(walVersion), reader.Record())
+	require.NoError(t, r.Close())
+
+	// reopening a WAL that isn't fresh doesn't write the header again
+	w, err = New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line"}, lines)
+	size, err := w.HeadSize()
+	require.NoError(t, err)
+	require.Zero(t, size)
+}
+
+func TestWAL_VersionHeaderAfterReset(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	requireLog(t, w, newTestRecord(1, "before delete"))
+	require.NoError(t, w.Delete())
+	require.NoError(t, w.(Extended).Reset())
+	requireLog(t, w, newTestRecord(2, "after reset"))
+	require.NoError(t, w.Sync())
+
+	r, err := wlog.NewSegmentsReader(dir)
+	require.NoError(t, err)
+	defer r.Close()
+	reader := wlog.NewReader(r)
+	require.True(t, reader.Next())
+	require.Equal(t, encodeVersionRecord(walVersion), reader.Record())
+	data, err := os.ReadFile(filepath.Join(dir, versionFileName))
+	require.NoError(t, err)
+	require.Equal(t, encodeVersionRecord(walVersion), data)
+}
+
+func TestWAL_VersionZero(t *testing.T) {
+	dir := t.TempDir()
+	writeRawWAL(t, dir, nil, "first", "second")
+
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, lines)
+
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+	require.True(t, r.Next())
+	require.NoError(t, r.Err())
+}
+
+func TestWAL_UnsupportedVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeRawWAL(t, dir, encodeVersionRecord(walVersion+1), "line")
+
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	err = w.Replay(func(*wal.Record) error {
+		t.Fatal("no record should be replayed")
+		return nil
+	})
+	require.ErrorIs(t, err, ErrUnsupportedWALVersion)
+
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+	require.False(t, r.Next())
+	require.ErrorIs(t, r.Err(), ErrUnsupportedWALVersion)
+
+	_, err = ReadWAL(dir)
+	require.ErrorIs(t, err, ErrUnsupportedWALVersion)
+}
+
+func TestWAL_UnsupportedVersionAfterHeaderRemoved(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(1, "first"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	requireLog(t, w, newTestRecord(2, "second"))
+	require.NoError(t, w.Close())
+
+	// a newer writer took over the WAL, and removed the segment holding the header
+	require.NoError(t, os.WriteFile(filepath.Join(dir, versionFileName), encodeVersionRecord(walVersion+1), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "00000000")))
+
+	w, err = New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	// reopening doesn't downgrade the version
+	data, err := os.ReadFile(filepath.Join(dir, versionFileName))
+	require.NoError(t, err)
+	require.Equal(t, encodeVersionRecord(walVersion+1), data)
+
+	err = w.Replay(func(*wal.Record) error {
+		t.Fatal("no record should be replayed")
+		return nil
+	})
+	require.ErrorIs(t, err, ErrUnsupportedWALVersion)
+	r, err := w.(Extended).NewReader()
+	require.NoError(t, err)
+	defer r.Close()
+	require.False(t, r.Next())
+	require.ErrorIs(t, r.Err(), ErrUnsupportedWALVersion)
+	_, err = ReadWAL(dir)
+	require.ErrorIs(t, err, ErrUnsupportedWALVersion)
+}
@@ -1,125 +1,1124 @@
 package wal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/multierror"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/prometheus/prometheus/tsdb/wlog"
+	"go.uber.org/atomic"
 
 	"github.com/grafana/loki/pkg/ingester/wal"
 )
 
 var (
-	recordPool = wal.NewRecordPool()
+	// ErrRecordTooLarge is returned by Log when an encoded record doesn't fit in a single WAL segment. Callers can
+	// split the record in smaller ones and retry.
+	ErrRecordTooLarge = errors.New("record too large")
+	// ErrClosed is returned by the operations writing to, or modifying the segments of, a WAL that was closed. Reading
+	// a closed WAL, as with Replay or Size, is still allowed.
+	ErrClosed = errors.New("WAL is closed")
+	// ErrEmptyRecord is returned when logging a record with neither series nor entries, if Config.StrictEmpty is set.
+	ErrEmptyRecord = errors.New("empty record")
+
+	errSegmentNotFound = errors.New("segment not found")
 )
 
+// SegmentError is returned by the operations on a given segment, such as DeleteSegment, Truncate and
+// WriteSegmentMeta, when they fail, recording the operation and the segment it failed on.
+type SegmentError struct {
+	SegmentNum int
+	// Op is the operation that failed: "delete", "truncate", "write meta" or "restore".
+	Op  string
+	Err error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("%s segment %d: %v", e.Op, e.SegmentNum, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
 // WAL is an interface that allows us to abstract ourselves from Prometheus WAL implementation.
 type WAL interface {
-	// Log marshals the records and writes it into the WAL.
-	Log(*wal.Record) error
+	// Log marshals the records and writes it into the WAL, returning the number of encoded bytes written. Records
+	// holding only series, which register labels for later entries, or only entries, referencing series logged
+	// before, are valid.
+	Log(*wal.Record) (int, error)
 
 	Delete() error
 	Sync() error
+	// SyncContext flushes changes to disk like Sync, but returns early if ctx is done before the flush finishes.
+	SyncContext(ctx context.Context) error
 	Dir() string
-	Close()
+	// Close flushes pending writes and closes the WAL. Closing an already closed WAL is a no-op returning nil. Afterwards,
+	// writes return ErrClosed.
+	Close() error
 	NextSegment() (int, error)
+
+	// Replay reads back all records persisted in the WAL, calling handler once per decoded record in segment order.
+	// The WAL may be locked while replaying, so handler must not call other methods of the WAL.
+	Replay(handler func(*wal.Record) error) error
+
+	// Size returns the sum of the sizes in bytes of all segments in the WAL directory.
+	Size() (int64, error)
+
+	// DeleteSegment removes the segment identified by segmentNum from the WAL directory.
+	DeleteSegment(segmentNum int) error
+
+	// Truncate removes all segments numbered strictly lower than upToSegment.
+	Truncate(upToSegment int) error
+
+	// CurrentSegment returns the number of the segment currently being written to, or -1 if there are no segments.
+	CurrentSegment() (int, error)
+
+	// DeleteOlderThan removes all segments last modified more than d ago, returning how many were removed.
+	DeleteOlderThan(d time.Duration) (int, error)
+
+	// LastWriteTime returns when a record was last successfully logged, or the zero time if none was.
+	LastWriteTime() time.Time
+
+	// CountSegments returns the number of segments in the WAL directory.
+	CountSegments() (int, error)
+
+	// Stats returns a summary of the WAL state, reading the WAL directory once.
+	Stats() (Stats, error)
+
+	// LogBatch writes all records in a single operation, that is never split across segments.
+	LogBatch(records []*wal.Record) error
+
+	// HeadSize returns the size in bytes of the segment currently being written to.
+	HeadSize() (int64, error)
+
+	// Segments returns the numbers of the segments in the WAL directory, in increasing order.
+	Segments() ([]int, error)
+}
+
+// Stats summarizes the state of a WAL.
+type Stats struct {
+	// Size is the sum of the sizes in bytes of all segments.
+	Size int64
+	// Segments is the number of segments.
+	Segments int
+	// OldestSegment and NewestSegment are the lowest and highest segment numbers, or -1 if there are no segments.
+	OldestSegment int
+	NewestSegment int
+	// LastWrite is when a record was last successfully logged, or the zero time if none was.
+	LastWrite time.Time
+	// SegmentRecords is the number of records in each segment, keyed by segment number. Series and entries of a logged
+	// record are written as separate records, and so are counted separately. Records still being buffered in memory,
+	// see Config.BufferSize, aren't counted.
+	SegmentRecords map[int]int
+}
+
+// NoopDir is the directory reported by a disabled WAL. It's not a valid path, so that callers that forget to check
+// IsNoop fail instead of operating on the working directory.
+const NoopDir = "<noop>"
+
+// noopWAL is a WAL that does nothing, used when the WAL is disabled.
+type noopWAL struct{}
+
+// IsNoop returns true if w is a disabled WAL, which has no directory and persists nothing.
+func IsNoop(w WAL) bool {
+	_, ok := w.(noopWAL)
+	return ok
 }
 
+func (noopWAL) Log(*wal.Record) (int, error)               { return 0, nil }
+func (noopWAL) Delete() error                              { return nil }
+func (noopWAL) Sync() error                                { return nil }
+func (noopWAL) SyncContext(context.Context) error          { return nil }
+func (noopWAL) Dir() string                                { return NoopDir }
+func (noopWAL) Close() error                               { return nil }
+func (noopWAL) NextSegment() (int, error)                  { return 0, nil }
+func (noopWAL) Replay(func(*wal.Record) error) error       { return nil }
+func (noopWAL) Size() (int64, error)                       { return 0, nil }
+func (noopWAL) DeleteSegment(int) error                    { return nil }
+func (noopWAL) Truncate(int) error                         { return nil }
+func (noopWAL) CurrentSegment() (int, error)               { return 0, nil }
+func (noopWAL) DeleteOlderThan(time.Duration) (int, error) { return 0, nil }
+func (noopWAL) LastWriteTime() time.Time                   { return time.Time{} }
+func (noopWAL) CountSegments() (int, error)                { return 0, nil }
+func (noopWAL) Stats() (Stats, error)                      { return Stats{}, nil }
+func (noopWAL) LogBatch([]*wal.Record) error               { return nil }
+func (noopWAL) HeadSize() (int64, error)                   { return 0, nil }
+func (noopWAL) Segments() ([]int, error)                   { return []int{}, nil }
+
+// writeLog is the subset of wlog.WL operations used by wrapper, allowing to inject fakes in tests.
+type writeLog interface {
+	Log(recs ...[]byte) error
+	Sync() error
+	Close() error
+	Dir() string
+	NextSegmentSync() (int, error)
+	Repair(origErr error) error
+}
+
+// wrapper is safe for concurrent use. Operations that append to the WAL (Log, Sync) may run concurrently with each
+// other, while operations that rotate, remove or scan segments (NextSegment, DeleteSegment, Truncate, Size,
+// CurrentSegment, Delete and Close) run exclusively, so that a scan never observes a segment being rotated underneath it.
 type wrapper struct {
-	wal *wlog.WL
-	log log.Logger
+	// mtx guards the segments in the WAL directory, see wrapper docs for the concurrency contract.
+	mtx  sync.RWMutex
+	wal  writeLog
+	fs   FS
+	log  log.Logger
+	pool *wal.ResettingPool
+
+	cfg          Config
+	startSegment int
+	// mirrorDir is the directory writes are mirrored to, if Config.MirrorDir is set.
+	mirrorDir string
+	// archiveDir is the directory evicted segments are archived to, if Config.ArchiveDir is set.
+	archiveDir string
+	// deletedSegments holds the segments removed but not yet notified to Config.OnSegmentDelete, guarded by mtx.
+	deletedSegments []int
+	// createdSegments holds the segments created but not yet notified to Config.OnSegmentCreate, guarded by mtx.
+	createdSegments []int
+	clientName      string
+	tenantID        string
+	metrics         *walMetrics
+	// wlogRegisterer tracks the metrics registered by the underlying wlog.WL, so that Reset can replace it. It's nil if
+	// metrics aren't registered.
+	wlogRegisterer *trackingRegisterer
+	// lastWrite holds the unix nanoseconds timestamp of the last successful Log call.
+	lastWrite atomic.Int64
+	// diskSizeUpdated holds the unix nanoseconds timestamp of the last update of the disk size metric.
+	diskSizeUpdated atomic.Int64
+	// preallocated holds the number of the last segment preallocated, see Config.Preallocate.
+	preallocated      atomic.Int64
+	preallocateFailed atomic.Bool
+	clock             clock
+	// syncDir fsyncs a directory, see Config.FsyncDir.
+	syncDir func(dir string) error
+
+	// quit stops the background sync routine run in SyncModeInterval, and closeOnce guards the shutdown sequence.
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	// closed is set once the underlying wal is closed, guarded by mtx.
+	closed bool
+	// buffer holds the records logged but not yet written, see Config.BufferSize.
+	buffer recordBuffer
+	// recordCounts caches the record counts of closed segments for Stats, guarded by mtx.
+	recordCounts map[int]segmentRecordCount
+	// statCache caches the directory listing for queries, see Config.StatCacheTTL.
+	statCache statCache
+	// readers tracks the segments open readers are positioned in, so that they aren't deleted.
+	readers *readerTracker
+	// syncer, if set, syncs the WAL in SyncModeInterval instead of a routine of its own.
+	syncer *Syncer
 }
 
-// New creates a new wrapper, instantiating the actual wlog.WL underneath.
+// New creates a new wrapper, instantiating the actual wlog.WL underneath. If the WAL is disabled, then the returned
+// WAL is a no-op WAL. Otherwise, it implements Extended.
 func New(cfg Config, log log.Logger, registerer prometheus.Registerer) (WAL, error) {
+	return newWAL(log, registerer, cfg, "", "")
+}
+
+// newWAL creates a WAL for the given client and tenant, written under cfg.Dir/clientName/tenantID unless a custom
+// layout is configured in cfg.DirFunc. Empty client and tenant names are omitted from the default layout.
+func newWAL(logger log.Logger, registerer prometheus.Registerer, cfg Config, clientName, tenantID string, opts ...walOption) (WAL, error) {
+	if !cfg.Enabled {
+		return noopWAL{}, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	logger = log.With(logger, "client", clientName, "tenant", tenantID, "component", "wal")
+
+	dir, err := cfg.walDir(clientName, tenantID)
+	if err != nil {
+		return nil, err
+	}
 	// TODO: We should fine-tune the WAL instantiated here to allow some buffering of written entries, but not written to disk
 	// yet. This will attest for the lack of buffering in the channel Writer exposes.
-	tsdbWAL, err := wlog.NewSize(log, registerer, cfg.Dir, wlog.DefaultSegmentSize, false)
+	var wlogRegisterer *trackingRegisterer
+	if registerer != nil {
+		wlogRegisterer = &trackingRegisterer{Registerer: registerer}
+		if clientName != "" || tenantID != "" {
+			// wlog registers its metrics unconditionally, so WALs sharing a registerer need to be told apart
+			wlogRegisterer.Registerer = prometheus.WrapRegistererWith(prometheus.Labels{"client": clientName, "tenant": tenantID}, registerer)
+		}
+	}
+	// opening is timed with the real clock, since the clock option can only be applied to the built wrapper
+	start := time.Now()
+	tsdbWAL, mirrorDir, err := openLog(logger, wlogRegisterer.registerer(), cfg, clientName, tenantID, dir)
+	if err != nil {
+		return nil, err
+	}
+	var archiveDir string
+	if cfg.ArchiveDir != "" && !cfg.ReadOnly {
+		if archiveDir, err = cfg.dirUnder(cfg.ArchiveDir, clientName, tenantID); err == nil {
+			err = checkWritable(archiveDir)
+		}
+		if err != nil {
+			_ = tsdbWAL.Close()
+			releaseDir(dir)
+			return nil, fmt.Errorf("failed to create WAL archive dir: %w", err)
+		}
+	}
+	// each WAL gets its own pool, so that buffers grown by a high volume client aren't handed out to others
+	pool := wal.NewRecordPoolWithBufferSize(cfg.RecordBufferSize)
+	w := &wrapper{
+		wal:            tsdbWAL,
+		fs:             cfg.filesystem(),
+		log:            logger,
+		pool:           pool,
+		cfg:            cfg,
+		clientName:     clientName,
+		tenantID:       tenantID,
+		mirrorDir:      mirrorDir,
+		archiveDir:     archiveDir,
+		metrics:        newWALMetrics(registerer),
+		wlogRegisterer: wlogRegisterer,
+		clock:          realClock{},
+		syncDir:        fsyncDir,
+		quit:           make(chan struct{}),
+	}
+	w.readers = newReaderTracker(w.metrics.openReaders.WithLabelValues(clientName, tenantID))
+	w.preallocated.Store(-1)
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.mtx.Lock()
+	err = w.opened()
+	w.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	w.openFinished(start)
+	w.startSyncLoop()
+	return w, nil
+}
+
+// openLog creates the writeLog persisting a WAL in dir, mirrored if configured, returning it along with the mirror
+// directory.
+func openLog(logger log.Logger, registerer prometheus.Registerer, cfg Config, clientName, tenantID, dir string) (writeLog, string, error) {
+	if cfg.ReadOnly {
+		wl, err := openReadOnlyLog(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		return wl, "", nil
+	}
+	if err := acquireDir(dir); err != nil {
+		return nil, "", err
+	}
+	wl, mirrorDir, err := openWritableLog(logger, registerer, cfg, clientName, tenantID, dir)
 	if err != nil {
-		return nil, fmt.Errorf("failde to create tsdb WAL: %w", err)
+		releaseDir(dir)
+		return nil, "", err
 	}
-	return &wrapper{
-		wal: tsdbWAL,
-		log: log,
-	}, nil
+	return wl, mirrorDir, nil
 }
 
-// Close closes the underlying wal, flushing pending writes and closing the active segment. Safe to call more than once
-func (w *wrapper) Close() {
-	// Avoid checking the error since it's safe to call Close more than once on wlog.WL
-	_ = w.wal.Close()
+// openWritableLog is openLog, for WALs not opened with Config.ReadOnly, once dir has been claimed.
+func openWritableLog(logger log.Logger, registerer prometheus.Registerer, cfg Config, clientName, tenantID, dir string) (writeLog, string, error) {
+	if err := checkWritable(dir); err != nil {
+		return nil, "", err
+	}
+	if err := fillSegmentGaps(logger, dir); err != nil {
+		return nil, "", err
+	}
+	var tsdbWAL writeLog = &dryRunLog{dir: dir}
+	if !cfg.DryRun {
+		wl, err := wlog.NewSize(logger, registerer, dir, cfg.segmentSize(), cfg.Compression)
+		if err != nil {
+			return nil, "", fmt.Errorf("failde to create tsdb WAL: %w", err)
+		}
+		tsdbWAL = wl
+	}
+	if cfg.MirrorDir == "" || cfg.DryRun {
+		return tsdbWAL, "", nil
+	}
+	mirror, mirrorDir, err := openMirror(logger, cfg, clientName, tenantID)
+	if err != nil {
+		_ = tsdbWAL.Close()
+		return nil, "", fmt.Errorf("failed to create mirror WAL: %w", err)
+	}
+	return &mirroredLog{writeLog: tsdbWAL, mirror: mirror}, mirrorDir, nil
 }
 
-func (w *wrapper) Delete() error {
-	err := w.wal.Close()
+// opened completes the setup of a freshly opened underlying wal, repairing it if configured. On error, the wal is
+// closed. Must be called with mtx held.
+func (w *wrapper) opened() error {
+	if mirrored, ok := w.wal.(*mirroredLog); ok {
+		mirrored.onMirrorErr = w.mirrorFailed
+	}
+	if w.cfg.RepairOnOpen {
+		if err := w.repair(); err != nil {
+			_ = w.closeWAL()
+			return fmt.Errorf("failed to repair WAL: %w", err)
+		}
+	}
+	if !w.cfg.ReadOnly {
+		if err := w.writeVersionRecord(); err != nil {
+			_ = w.closeWAL()
+			return err
+		}
+	}
+	if !w.cfg.ReadOnly && !w.cfg.DryRun {
+		if err := writeVersionFile(w.wal.Dir()); err != nil {
+			_ = w.closeWAL()
+			return err
+		}
+		if w.mirrorDir != "" {
+			if err := writeVersionFile(w.mirrorDir); err != nil {
+				w.mirrorFailed("write version", err)
+			}
+		}
+	}
+	// wlog always starts writing to a new segment, numbered after the highest existing one
+	var err error
+	if w.startSegment, err = w.currentSegment(); err != nil {
+		_ = w.closeWAL()
+		return err
+	}
+	if !w.cfg.ReadOnly {
+		w.preallocateHead()
+	}
+	w.metrics.oldestSegmentAge.add(w, w.wal.Dir())
+	return nil
+}
+
+// openFinished records how long opening the WAL took since start, including the initial scan of its segments, and
+// warns if it took longer than Config.SlowOpenThreshold.
+func (w *wrapper) openFinished(start time.Time) {
+	took := time.Since(start)
+	w.metrics.openDuration.WithLabelValues(w.clientName, w.tenantID).Observe(took.Seconds())
+	if threshold := w.cfg.slowOpenThreshold(); took > threshold {
+		level.Warn(w.log).Log("msg", "opening WAL was slow", "duration", took, "threshold", threshold, "startSegment", w.startSegment)
+	}
+}
+
+// startSyncLoop starts the background sync routine when running in SyncModeInterval, or registers the WAL with its
+// Syncer if it has one.
+func (w *wrapper) startSyncLoop() {
+	if w.cfg.SyncMode == SyncModeInterval {
+		if w.syncer != nil {
+			w.syncer.register(w)
+			return
+		}
+		w.wg.Add(1)
+		go w.syncLoop(w.clock.NewTicker(w.cfg.SyncInterval))
+	}
+}
+
+// checkWritable creates dir if needed, and checks a file can be written in it, so that a misconfigured directory is
+// reported clearly instead of failing inside wlog.
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return fmt.Errorf("wal dir %q is not writable: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-check-")
 	if err != nil {
+		return fmt.Errorf("wal dir %q is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// fillSegmentGaps creates empty segments in dir between non-sequential existing segments, which can be left behind by
+// DeleteSegment. wlog refuses to open a directory with gaps, and empty segments are read as having no records.
+func fillSegmentGaps(logger log.Logger, dir string) error {
+	segments, err := readSegmentRefs(OSFS{}, dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading segments in wal directory: %w", err)
+	}
+	for i := 0; i < len(segments)-1; i++ {
+		for missing := segments[i].number + 1; missing < segments[i+1].number; missing++ {
+			level.Warn(logger).Log("msg", "creating empty segment to fill gap in WAL", "segment", missing)
+			segment, err := wlog.CreateSegment(dir, missing)
+			if err != nil {
+				return fmt.Errorf("error filling gap in wal segments: %w", err)
+			}
+			_ = segment.Close()
+		}
+	}
+	return nil
+}
+
+// StartSegment returns the segment this WAL started writing to when created. When reopening an existing WAL, it's
+// numbered after the highest segment found.
+func (w *wrapper) StartSegment() int {
+	return w.startSegment
+}
+
+// GetRecord returns a reset record from the WAL pool, which callers can use to build records to log. It should be
+// returned with PutRecord once logged.
+func (w *wrapper) GetRecord() *wal.Record {
+	return w.pool.GetRecord()
+}
+
+// PutRecord returns a record obtained with GetRecord to the WAL pool.
+func (w *wrapper) PutRecord(rec *wal.Record) {
+	w.pool.PutRecord(rec)
+}
+
+// LastWriteTime returns when a record was last successfully logged, or the zero time if none was since this WAL was
+// created.
+func (w *wrapper) LastWriteTime() time.Time {
+	nanos := w.lastWrite.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// syncLoop syncs the WAL every time ticker fires, until the wrapper is closed.
+func (w *wrapper) syncLoop(ticker ticker) {
+	defer w.wg.Done()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := w.Sync(); err != nil {
+				level.Error(w.log).Log("msg", "failed to sync WAL", "err", err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// shutdown stops the background sync routine, if any, performing a final sync when running in SyncModeInterval. Only
+// the first call has any effect. Must be called without holding mtx.
+func (w *wrapper) shutdown() {
+	w.closeOnce.Do(func() {
+		if w.syncer != nil {
+			w.syncer.deregister(w)
+		}
+		close(w.quit)
+		w.wg.Wait()
+		if w.cfg.SyncMode == SyncModeInterval {
+			if err := w.Sync(); err != nil {
+				level.Warn(w.log).Log("msg", "failed to sync WAL on close", "err", err)
+			}
+		}
+	})
+}
+
+// Close closes the underlying wal, flushing pending writes and closing the active segment. Safe to call more than
+// once, calls after the first one, or after Delete, are no-ops returning nil.
+func (w *wrapper) Close() error {
+	w.shutdown()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.closeWAL()
+}
+
+func (w *wrapper) Delete() error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	w.shutdown()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	// the directory is removed even if closing fails, but both errors are returned
+	errs := multierror.New()
+	if err := w.closeWAL(); err != nil {
+		level.Warn(w.log).Log("msg", "failed to close WAL", "err", err)
+		errs.Add(fmt.Errorf("failed to close WAL: %w", err))
+	}
+	if err := os.RemoveAll(w.wal.Dir()); err != nil {
+		errs.Add(fmt.Errorf("failed to remove WAL directory: %w", err))
+	}
+	w.invalidateStatCache()
+	if w.mirrorDir != "" {
+		if err := os.RemoveAll(w.mirrorDir); err != nil {
+			w.mirrorFailed("delete", err)
+		}
+	}
+	return errs.Err()
+}
+
+// Reset reopens the WAL on the same directory and with the same configuration, so that it can be used again after
+// being closed or deleted without building a new one. As when creating a WAL, existing segments are kept, so Delete
+// should be called first to start from an empty WAL. A deleted directory is recreated atomically, so that readers of it
+// never see it empty. Reset must not be called concurrently with Close or Delete.
+func (w *wrapper) Reset() error {
+	w.shutdown()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.closeWAL(); err != nil {
 		level.Warn(w.log).Log("msg", "failed to close WAL", "err", err)
 	}
-	err = os.RemoveAll(w.wal.Dir())
-	return err
+	// the metrics of the closed wlog.WL must be unregistered, since registering the new ones would panic otherwise
+	if w.wlogRegisterer != nil {
+		w.wlogRegisterer.unregisterAll()
+	}
+	start := time.Now()
+	if !w.cfg.ReadOnly {
+		if err := w.recreateDir(w.wal.Dir()); err != nil {
+			return fmt.Errorf("failed to reset WAL: %w", err)
+		}
+	}
+	tsdbWAL, mirrorDir, err := openLog(w.log, w.wlogRegisterer.registerer(), w.cfg, w.clientName, w.tenantID, w.wal.Dir())
+	if err != nil {
+		return fmt.Errorf("failed to reset WAL: %w", err)
+	}
+	w.wal, w.mirrorDir, w.closed = tsdbWAL, mirrorDir, false
+	w.invalidateStatCache()
+	// the counts cached for the segments before the reset don't describe the segments numbered the same after it
+	w.recordCounts = nil
+	// segments are numbered from zero again if the WAL was deleted
+	w.preallocated.Store(-1)
+	if err := w.opened(); err != nil {
+		return fmt.Errorf("failed to reset WAL: %w", err)
+	}
+	w.openFinished(start)
+	w.quit, w.closeOnce = make(chan struct{}), sync.Once{}
+	w.startSyncLoop()
+	return nil
+}
+
+// recreateDir recreates the WAL directory dir if it was removed, as Delete does, holding a first segment with only the
+// version header. The directory is populated under a temporary name next to it, and renamed into place, so that
+// readers of dir never see it empty. Segments are then written after the first one, as when opening a WAL. Must be
+// called with mtx held.
+func (w *wrapper) recreateDir(dir string) error {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return err
+	}
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0o777); err != nil {
+		return err
+	}
+	tmp := filepath.Join(parent, "."+filepath.Base(dir)+".reset")
+	// a previous reset might have crashed before renaming it
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0o777); err != nil {
+		return err
+	}
+	if err := seedDir(tmp, w.cfg.Compression); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := fileutil.Rename(tmp, dir); err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("error moving WAL dir into place: %w", err)
+	}
+	if w.cfg.FsyncDir {
+		return w.syncDir(parent)
+	}
+	return nil
+}
+
+// seedDir populates the empty directory dir as opening a fresh WAL in it would, with a first segment holding the
+// version header record, and the version file.
+func seedDir(dir string, compression bool) error {
+	wl, err := wlog.New(log.NewNopLogger(), nil, dir, compression)
+	if err != nil {
+		return err
+	}
+	if err := wl.Log(encodeVersionRecord(walVersion)); err != nil {
+		_ = wl.Close()
+		return fmt.Errorf("failed to write WAL version: %w", err)
+	}
+	if err := wl.Close(); err != nil {
+		return err
+	}
+	return writeVersionFile(dir)
+}
+
+// checkOpen returns ErrClosed if the WAL was closed. Must be called with mtx held, either for reading or writing, and
+// kept held while using the underlying wal, so that it can't be closed in between.
+func (w *wrapper) checkOpen() error {
+	if w.closed {
+		return ErrClosed
+	}
+	return nil
 }
 
-func (w *wrapper) Log(record *wal.Record) error {
-	if record == nil || (len(record.Series) == 0 && len(record.RefEntries) == 0) {
+// closeWAL closes the underlying wal if not closed yet, since wlog.WL errors when closed twice. Must be called with mtx
+// held.
+func (w *wrapper) closeWAL() error {
+	if w.closed {
 		return nil
 	}
+	w.closed = true
+	w.metrics.oldestSegmentAge.remove(w)
+	// buffered records are still written if flushing fails, so that the underlying wal is closed anyway
+	flushErr := w.flushBuffer()
+	if !w.cfg.ReadOnly {
+		defer releaseDir(w.wal.Dir())
+	}
+	if err := w.wal.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
 
-	// The code below extracts the wal write operations to when possible, batch both series and records writes
-	if len(record.Series) > 0 && len(record.RefEntries) > 0 {
-		return w.logBatched(record)
+// Log marshals the records and writes it into the WAL, returning the number of encoded bytes written. Series are
+// written before entries, as separate WAL records, and either of them is skipped if empty. Records with neither series
+// nor entries aren't written, and fail with ErrEmptyRecord if Config.StrictEmpty is set.
+func (w *wrapper) Log(record *wal.Record) (int, error) {
+	return w.LogContext(context.Background(), record)
+}
+
+// LogContext is like Log, but returns ctx.Err() without writing anything if ctx is already done. Once started, the
+// write to disk can't be interrupted, so it's only checked before writing.
+func (w *wrapper) LogContext(ctx context.Context, record *wal.Record) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := w.checkWrite(); err != nil {
+		return 0, err
+	}
+	record, ok := w.applyLogHook(record)
+	if !ok {
+		return 0, nil
+	}
+	var written int
+	err := w.logFreeingSpace(resumingFlush(func() (err error) {
+		written, err = w.logRecord(record)
+		return err
+	}, w.Flush))
+	if err != nil {
+		w.logFailed(err)
+		return written, err
+	}
+	if written > 0 {
+		level.Debug(w.log).Log("msg", "logged record to WAL", "bytes", written)
+		w.wroteRecords()
 	}
-	return w.logSingle(record)
+	return written, nil
 }
 
-// logBatched logs to the WAL both series and records, batching the operation to prevent unnecessary page flushes.
-func (w *wrapper) logBatched(record *wal.Record) error {
-	seriesBuf := recordPool.GetBytes()
-	entriesBuf := recordPool.GetBytes()
-	defer func() {
-		recordPool.PutBytes(seriesBuf)
-		recordPool.PutBytes(entriesBuf)
-	}()
+// LogTracked is like Log, but returns the number of the segment the record was written to, which is the segment current
+// right after the write. Unlike calling CurrentSegment after Log, concurrent writes can't rotate the WAL in between,
+// since they're blocked until the segment is found. Series and entries are written as separate WAL records, so in the
+// unlikely case of a rotation in the middle of the write, the series end up in the previous segment.
+func (w *wrapper) LogTracked(record *wal.Record) (int, error) {
+	if err := w.checkWrite(); err != nil {
+		return -1, err
+	}
+	record, ok := w.applyLogHook(record)
+	if !ok {
+		return -1, nil
+	}
+	var segment, written int
+	err := w.logFreeingSpace(resumingFlush(func() (err error) {
+		segment, written, err = w.logTracked(record)
+		return err
+	}, func() (err error) {
+		segment, err = w.flushTracked()
+		return err
+	}))
+	if err != nil {
+		w.logFailed(err)
+		return -1, err
+	}
+	if written > 0 {
+		level.Debug(w.log).Log("msg", "logged record to WAL", "segment", segment, "bytes", written)
+		w.wroteRecords()
+	}
+	return segment, nil
+}
 
-	*seriesBuf = record.EncodeSeries(*seriesBuf)
-	*entriesBuf = record.EncodeEntries(wal.CurrentEntriesRec, *entriesBuf)
-	// Always write series then entries
-	if err := w.wal.Log(*seriesBuf, *entriesBuf); err != nil {
+// LogAll logs records one after the other, as calling Log for each of them would, but reusing the same encoding
+// buffers for all of them, which saves pool traffic when logging many small records. It stops at the first record
+// failing to be logged, returning its error, with the records before it already written. Records written are logged
+// once at debug level, as a whole.
+func (w *wrapper) LogAll(records []*wal.Record) error {
+	if err := w.checkWrite(); err != nil {
 		return err
 	}
+	seriesBuf := w.pool.GetBytes()
+	entriesBuf := w.pool.GetBytes()
+	var logged, total int
+	defer func() {
+		w.pool.PutBytes(seriesBuf)
+		w.pool.PutBytes(entriesBuf)
+		if logged > 0 {
+			level.Debug(w.log).Log("msg", "logged records to WAL", "records", logged, "bytes", total)
+		}
+	}()
+	for _, record := range records {
+		record, ok := w.applyLogHook(record)
+		if !ok {
+			continue
+		}
+		var written int
+		err := w.logFreeingSpace(resumingFlush(func() (err error) {
+			written, err = w.logRecordBuffered(record, seriesBuf, entriesBuf)
+			return err
+		}, w.Flush))
+		if err != nil {
+			w.logFailed(err)
+			return err
+		}
+		if written > 0 {
+			logged++
+			total += written
+			w.wroteRecords()
+		}
+	}
 	return nil
 }
 
-// logSingle logs to the WAL series and records in separate WAL operation. This causes a page flush after each operation.
-func (w *wrapper) logSingle(record *wal.Record) error {
-	buf := recordPool.GetBytes()
+func (w *wrapper) logTracked(record *wal.Record) (int, int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return -1, 0, err
+	}
+	written, err := w.writeRecord(record)
+	if err != nil {
+		return -1, written, err
+	}
+	// the record must be in a segment before looking for it
+	if err := w.flushBuffer(); err != nil {
+		return -1, written, w.pendingFlush(err)
+	}
+	segment, err := w.currentSegment()
+	return segment, written, err
+}
+
+// flushTracked resumes logTracked once the record it buffered failed to be flushed, flushing it and returning the
+// segment it was written to.
+func (w *wrapper) flushTracked() (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return -1, err
+	}
+	if err := w.flushBuffer(); err != nil {
+		return -1, w.pendingFlush(err)
+	}
+	return w.currentSegment()
+}
+
+// applyLogHook passes record through Config.LogHook, if set, returning the record to write instead, and false if it
+// must be skipped.
+func (w *wrapper) applyLogHook(record *wal.Record) (*wal.Record, bool) {
+	if w.cfg.LogHook == nil {
+		return record, true
+	}
+	return w.cfg.LogHook(record)
+}
+
+// Reasons failed attempts to log records are counted by.
+const (
+	logErrorEncode   = "encode"
+	logErrorTooLarge = "too_large"
+	logErrorDiskFull = "disk_full"
+	logErrorEmpty    = "empty"
+	logErrorWrite    = "write"
+)
+
+// logFailed counts a failed attempt to log records, classifying err into a coarse reason.
+func (w *wrapper) logFailed(err error) {
+	reason := logErrorWrite
+	switch {
+	case errors.As(err, &encodeError{}):
+		reason = logErrorEncode
+	case errors.Is(err, ErrRecordTooLarge):
+		reason = logErrorTooLarge
+	case errors.Is(err, ErrDiskFull):
+		reason = logErrorDiskFull
+	case errors.Is(err, ErrEmptyRecord):
+		reason = logErrorEmpty
+	}
+	w.metrics.logErrors.WithLabelValues(w.clientName, w.tenantID, reason).Inc()
+}
+
+// wroteRecords is called after records are successfully logged, tracking the write time and evicting old segments if
+// the WAL has grown over its max size or max segments.
+func (w *wrapper) wroteRecords() {
+	now := w.clock.Now()
+	w.lastWrite.Store(now.UnixNano())
+	w.metrics.lastWriteTimestamp.WithLabelValues(w.clientName, w.tenantID).Set(float64(now.UnixNano()) / 1e9)
+	if w.cfg.MaxSize > 0 || w.cfg.MaxSegments > 0 {
+		if err := w.evictOverLimits(); err != nil {
+			level.Warn(w.log).Log("msg", "failed to evict WAL segments over the max size or max segments", "err", err)
+		}
+	}
+	w.updateDiskSize(now)
+}
+
+// updateDiskSize refreshes the disk size metric, unless it was already updated less than
+// Config.DiskSizeUpdateInterval ago.
+func (w *wrapper) updateDiskSize(now time.Time) {
+	last := w.diskSizeUpdated.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < w.cfg.diskSizeUpdateInterval() {
+		return
+	}
+	// only one of the concurrent writers that found the metric outdated updates it
+	if !w.diskSizeUpdated.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	size, err := w.Size()
+	if err != nil {
+		level.Warn(w.log).Log("msg", "failed to update WAL disk size", "err", err)
+		return
+	}
+	w.metrics.diskSize.WithLabelValues(w.clientName, w.tenantID).Set(float64(size))
+}
+
+// logRecord encodes and writes record into the WAL, syncing it if configured to do so.
+func (w *wrapper) logRecord(record *wal.Record) (int, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	if err := w.checkOpen(); err != nil {
+		return 0, err
+	}
+	return w.writeRecord(record)
+}
+
+// logRecordBuffered is logRecord, encoding record into the given buffers.
+func (w *wrapper) logRecordBuffered(record *wal.Record, seriesBuf, entriesBuf *[]byte) (int, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	if err := w.checkOpen(); err != nil {
+		return 0, err
+	}
+	return w.writeRecordBuffered(record, seriesBuf, entriesBuf)
+}
+
+// writeRecord encodes and writes record to the WAL, syncing if configured. Must be called with mtx held, either for
+// reading or writing.
+func (w *wrapper) writeRecord(record *wal.Record) (int, error) {
+	if isEmptyRecord(record) {
+		return 0, w.emptyRecordErr()
+	}
+	seriesBuf := w.pool.GetBytes()
+	entriesBuf := w.pool.GetBytes()
 	defer func() {
-		recordPool.PutBytes(buf)
+		w.pool.PutBytes(seriesBuf)
+		w.pool.PutBytes(entriesBuf)
 	}()
+	return w.writeRecordBuffered(record, seriesBuf, entriesBuf)
+}
+
+// writeRecordBuffered is writeRecord, encoding record into the given buffers, which are reset first.
+func (w *wrapper) writeRecordBuffered(record *wal.Record, seriesBuf, entriesBuf *[]byte) (int, error) {
+	if isEmptyRecord(record) {
+		return 0, w.emptyRecordErr()
+	}
+
+	var written int
+	var err error
+	// The code below extracts the wal write operations to when possible, batch both series and records writes
+	if w.cfg.Encoder != nil {
+		written, err = w.logEncoded(record)
+	} else if len(record.Series) > 0 && len(record.RefEntries) > 0 {
+		written, err = w.logBatched(record, seriesBuf, entriesBuf)
+	} else {
+		written, err = w.logSingle(record, seriesBuf)
+	}
+	if err != nil {
+		return written, err
+	}
+	w.preallocateHead()
+	if w.cfg.SyncMode == SyncModePerRecord {
+		if err := w.syncWAL(); err != nil {
+			return written, fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+	return written, nil
+}
+
+// isEmptyRecord returns whether record has neither series nor entries, so that there's nothing to write for it.
+func isEmptyRecord(record *wal.Record) bool {
+	return record == nil || (len(record.Series) == 0 && len(record.RefEntries) == 0)
+}
+
+// emptyRecordErr returns the error logging an empty record fails with, which is nil unless Config.StrictEmpty is set.
+func (w *wrapper) emptyRecordErr() error {
+	if w.cfg.StrictEmpty {
+		return ErrEmptyRecord
+	}
+	return nil
+}
+
+// logBatched logs to the WAL both series and records, batching the operation to prevent unnecessary page flushes.
+func (w *wrapper) logBatched(record *wal.Record, seriesBuf, entriesBuf *[]byte) (int, error) {
+	*seriesBuf = encodeRecord((*seriesBuf)[:0], w.cfg.RecordChecksums, encodeSeries(record, w.cfg.DeterministicEncoding))
+	*entriesBuf = encodeRecord((*entriesBuf)[:0], w.cfg.RecordChecksums, encodeEntries(record, w.cfg.entriesRecordVersion()))
+	if err := w.checkRecordSize(*seriesBuf); err != nil {
+		return 0, err
+	}
+	if err := w.checkRecordSize(*entriesBuf); err != nil {
+		return 0, err
+	}
+	// Always write series then entries
+	if err := w.logRecords(*seriesBuf, *entriesBuf); err != nil {
+		return 0, err
+	}
+	w.recordLogged(len(*seriesBuf))
+	w.recordLogged(len(*entriesBuf))
+	return len(*seriesBuf) + len(*entriesBuf), nil
+}
 
+// logSingle logs to the WAL series and records in separate WAL operation. This causes a page flush after each operation.
+func (w *wrapper) logSingle(record *wal.Record, buf *[]byte) (int, error) {
+	var written int
 	// Always write series then entries.
 	if len(record.Series) > 0 {
-		*buf = record.EncodeSeries(*buf)
-		if err := w.wal.Log(*buf); err != nil {
-			return err
+		*buf = encodeRecord((*buf)[:0], w.cfg.RecordChecksums, encodeSeries(record, w.cfg.DeterministicEncoding))
+		if err := w.checkRecordSize(*buf); err != nil {
+			return written, err
+		}
+		if err := w.logRecords(*buf); err != nil {
+			return written, err
 		}
-		*buf = (*buf)[:0]
+		w.recordLogged(len(*buf))
+		written += len(*buf)
 	}
 	if len(record.RefEntries) > 0 {
-		*buf = record.EncodeEntries(wal.CurrentEntriesRec, *buf)
-		if err := w.wal.Log(*buf); err != nil {
-			return err
+		*buf = encodeRecord((*buf)[:0], w.cfg.RecordChecksums, encodeEntries(record, w.cfg.entriesRecordVersion()))
+		if err := w.checkRecordSize(*buf); err != nil {
+			return written, err
+		}
+		if err := w.logRecords(*buf); err != nil {
+			return written, err
+		}
+		w.recordLogged(len(*buf))
+		written += len(*buf)
+	}
+	return written, nil
+}
+
+// EstimateSize encodes record as Log would, returning the number of encoded bytes Log would write for it, without
+// writing anything. Like Log, it fails with ErrRecordTooLarge if an encoded part doesn't fit in a segment, so combined
+// with HeadSize and the segment size, it tells callers whether a record fits in the current segment before logging it.
+// Config.LogHook isn't applied, and compression isn't accounted for.
+func (w *wrapper) EstimateSize(record *wal.Record) (int, error) {
+	if isEmptyRecord(record) {
+		return 0, nil
+	}
+	if w.cfg.Encoder != nil {
+		recs, err := w.encodeCustom(record)
+		if err != nil {
+			return 0, err
+		}
+		var size int
+		for _, rec := range recs {
+			if err := w.checkRecordSize(rec); err != nil {
+				return 0, err
+			}
+			size += len(rec)
+		}
+		return size, nil
+	}
+	buf := w.pool.GetBytes()
+	defer w.pool.PutBytes(buf)
+	var size int
+	if len(record.Series) > 0 {
+		*buf = encodeRecord((*buf)[:0], w.cfg.RecordChecksums, encodeSeries(record, w.cfg.DeterministicEncoding))
+		if err := w.checkRecordSize(*buf); err != nil {
+			return 0, err
+		}
+		size += len(*buf)
+	}
+	if len(record.RefEntries) > 0 {
+		*buf = encodeRecord((*buf)[:0], w.cfg.RecordChecksums, encodeEntries(record, w.cfg.entriesRecordVersion()))
+		if err := w.checkRecordSize(*buf); err != nil {
+			return 0, err
+		}
+		size += len(*buf)
+	}
+	return size, nil
+}
+
+// encodeSeries returns a function encoding the series of record, sorting the labels of each series first if
+// deterministic is set.
+func encodeSeries(record *wal.Record, deterministic bool) func([]byte) []byte {
+	if !deterministic {
+		return record.EncodeSeries
+	}
+	sorted := &wal.Record{UserID: record.UserID, Series: sortedSeries(record.Series)}
+	return sorted.EncodeSeries
+}
+
+// sortedSeries returns series with the labels of each series sorted by name, copying only the labels that aren't
+// sorted already, so that series isn't modified.
+func sortedSeries(series []record.RefSeries) []record.RefSeries {
+	sorted := make([]record.RefSeries, len(series))
+	for i, s := range series {
+		if !sort.IsSorted(s.Labels) {
+			s.Labels = append(labels.Labels(nil), s.Labels...)
+			sort.Stable(s.Labels)
 		}
+		sorted[i] = s
+	}
+	return sorted
+}
 
+// encodeEntries returns a function encoding the entries of record with the given entries record version.
+func encodeEntries(record *wal.Record, version wal.RecordType) func([]byte) []byte {
+	return func(b []byte) []byte {
+		return record.EncodeEntries(version, b)
+	}
+}
+
+// checkRecordSize returns ErrRecordTooLarge if the encoded record doesn't fit in a segment.
+func (w *wrapper) checkRecordSize(buf []byte) error {
+	if limit := w.cfg.segmentSize(); len(buf) > limit {
+		return fmt.Errorf("%w: encoded record of %d bytes exceeds the segment size of %d bytes", ErrRecordTooLarge, len(buf), limit)
 	}
 	return nil
 }
 
+// recordLogged accounts for a single encoded record of the given size written to the WAL.
+func (w *wrapper) recordLogged(size int) {
+	if w.cfg.DryRun {
+		w.metrics.dryRunBytes.WithLabelValues(w.clientName, w.tenantID).Add(float64(size))
+		return
+	}
+	w.metrics.recordsLogged.WithLabelValues(w.clientName, w.tenantID).Inc()
+	w.metrics.bytesLogged.WithLabelValues(w.clientName, w.tenantID).Add(float64(size))
+}
+
 // Sync flushes changes to disk. Mainly to be used for testing.
 func (w *wrapper) Sync() error {
-	return w.wal.Sync()
+	return w.SyncContext(context.Background())
+}
+
+// SyncContext flushes changes to disk, returning ctx.Err() if ctx is done before the flush finishes. In that case, the
+// flush is left running in the background.
+func (w *wrapper) SyncContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		w.mtx.RLock()
+		defer w.mtx.RUnlock()
+		if err := w.checkOpen(); err != nil {
+			done <- err
+			return
+		}
+		done <- w.syncWAL()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		level.Warn(w.log).Log("msg", "context done before WAL sync finished, an orphaned sync may still be in flight", "err", ctx.Err())
+		return ctx.Err()
+	}
 }
 
 // Dir returns the path to the WAL directory.
@@ -129,5 +1128,303 @@ func (w *wrapper) Dir() string {
 
 // NextSegment closes the current segment synchronously. Mainly used for testing.
 func (w *wrapper) NextSegment() (int, error) {
-	return w.wal.NextSegmentSync()
+	if err := w.checkWrite(); err != nil {
+		return 0, err
+	}
+	defer w.notifySegmentsDeleted()
+	defer w.notifySegmentsCreated()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return 0, err
+	}
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+	return w.nextSegment()
+}
+
+// Rotate seals the segment currently being written to, syncing it, and rotates to a new one, returning its number.
+// Unlike NextSegment, everything logged before Rotate, including buffered records, is guaranteed to be on disk once it
+// returns, so that all segments before the returned one can be treated as complete, for example to snapshot them.
+func (w *wrapper) Rotate() (int, error) {
+	if err := w.checkWrite(); err != nil {
+		return 0, err
+	}
+	defer w.notifySegmentsDeleted()
+	defer w.notifySegmentsCreated()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return 0, err
+	}
+	if err := w.syncWAL(); err != nil {
+		return 0, err
+	}
+	return w.nextSegment()
+}
+
+// nextSegment rotates the underlying wal to a new segment, evicting segments over Config.MaxSegments. Must be called
+// with mtx held, and the buffer flushed.
+func (w *wrapper) nextSegment() (int, error) {
+	segment, err := w.wal.NextSegmentSync()
+	if err != nil {
+		return segment, err
+	}
+	level.Debug(w.log).Log("msg", "rotated WAL to a new segment", "segment", segment)
+	w.metrics.segmentsCreated.WithLabelValues(w.clientName, w.tenantID).Inc()
+	w.segmentCreated(segment)
+	w.preallocate(segment)
+	if err := w.dirChanged(); err != nil {
+		return segment, err
+	}
+	if w.cfg.MaxSegments > 0 {
+		if err := w.evictOverLimitsLocked(); err != nil {
+			level.Warn(w.log).Log("msg", "failed to evict WAL segments over the max segments", "err", err)
+		}
+	}
+	return segment, nil
+}
+
+// Size returns the sum of the sizes in bytes of all segments in the WAL directory. Files not named as a segment are
+// ignored.
+func (w *wrapper) Size() (int64, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	segments, err := w.cachedSegmentRefs()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, segment := range segments {
+		size += segment.size
+	}
+	return size, nil
+}
+
+// CountSegments returns the number of segments in the WAL directory, that is, files with a numeric name.
+func (w *wrapper) CountSegments() (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	segments, err := w.cachedSegmentRefs()
+	if err != nil {
+		return 0, err
+	}
+	return len(segments), nil
+}
+
+// Segments returns the numbers of the segments in the WAL directory, that is, files with a numeric name, in increasing
+// order. Gaps left by removed segments are kept.
+func (w *wrapper) Segments() ([]int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	refs, err := w.cachedSegmentRefs()
+	if err != nil {
+		return nil, err
+	}
+	return segmentRefNumbers(refs), nil
+}
+
+// segments is Segments, but always reads the WAL directory, and must be called with mtx held.
+func (w *wrapper) segments() ([]int, error) {
+	refs, err := w.segmentRefs()
+	if err != nil {
+		return nil, err
+	}
+	return segmentRefNumbers(refs), nil
+}
+
+// segmentRefNumbers returns the numbers of the given segments.
+func segmentRefNumbers(refs []segmentRef) []int {
+	numbers := make([]int, 0, len(refs))
+	for _, ref := range refs {
+		numbers = append(numbers, ref.number)
+	}
+	return numbers
+}
+
+// Stats returns a summary of the WAL state, computed from a single read of the WAL directory. Counting the records in
+// each segment also reads the head segment, and any segment not seen by a previous call.
+func (w *wrapper) Stats() (Stats, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	segments, err := w.cachedSegmentRefs()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{
+		Segments:      len(segments),
+		OldestSegment: -1,
+		NewestSegment: -1,
+		LastWrite:     w.LastWriteTime(),
+	}
+	for _, segment := range segments {
+		stats.Size += segment.size
+	}
+	if len(segments) > 0 {
+		stats.OldestSegment = segments[0].number
+		stats.NewestSegment = segments[len(segments)-1].number
+	}
+	stats.SegmentRecords = w.segmentRecords(segments)
+	return stats, nil
+}
+
+// DeleteSegment removes the segment identified by segmentNum from the WAL directory. An error is returned if no such
+// segment exists. Deleting the segment an open RecordReader or Watch is positioned in logs a warning.
+func (w *wrapper) DeleteSegment(segmentNum int) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	defer w.notifySegmentsDeleted()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	if w.readers.inUse(segmentNum) {
+		// readers keep reading the segment already opened, but lose whatever wasn't flushed when they opened it
+		level.Warn(w.log).Log("msg", "deleting WAL segment an open reader is positioned in", "segment", segmentNum)
+	}
+	if err := w.deleteSegment(segmentNum); err != nil {
+		return &SegmentError{SegmentNum: segmentNum, Op: "delete", Err: err}
+	}
+	return nil
+}
+
+// deleteSegment removes the segment identified by segmentNum. Must be called with mtx held.
+func (w *wrapper) deleteSegment(segmentNum int) error {
+	// segments are usually named as configured, so try that first to avoid listing the whole directory
+	err := w.removeSegment(w.cfg.segmentName(segmentNum), segmentNum)
+	if err == nil {
+		return w.dirChanged()
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.number == segmentNum {
+			if err := w.removeSegment(segment.name, segment.number); err != nil {
+				return err
+			}
+			return w.dirChanged()
+		}
+	}
+	return errSegmentNotFound
+}
+
+// Truncate removes all segments numbered strictly lower than upToSegment. Segments already removed are skipped, and the
+// segment currently being written to is never removed. Neither are the segments an open RecordReader or Watch is
+// positioned in or still has to read, which are kept logging a warning.
+//
+// Truncate also advances the checkpoint to upToSegment, or to the segment currently being written to if before it, so
+// that segments kept for open readers aren't replayed again, see Checkpoint.
+func (w *wrapper) Truncate(upToSegment int) error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	defer w.notifySegmentsDeleted()
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	segments, err := w.segmentRefs()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	head := segments[len(segments)-1].number
+	checkpoint := upToSegment
+	if checkpoint > head {
+		checkpoint = head
+	}
+	if err := w.advanceCheckpoint(checkpoint); err != nil {
+		return err
+	}
+	if oldest := w.readers.oldest(); oldest >= 0 && oldest < upToSegment {
+		level.Warn(w.log).Log("msg", "not truncating WAL segments still needed by open readers", "upToSegment", upToSegment, "oldestReaderSegment", oldest)
+		upToSegment = oldest
+	}
+	var removed bool
+	for _, segment := range segments {
+		if segment.number >= upToSegment || segment.number == head {
+			break
+		}
+		if err := w.removeSegment(segment.name, segment.number); err != nil && !os.IsNotExist(err) {
+			return &SegmentError{SegmentNum: segment.number, Op: "truncate", Err: err}
+		}
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return w.dirChanged()
+}
+
+// CurrentSegment returns the number of the segment currently being written to, that is, the highest numbered segment in
+// the WAL directory. If there are no segments, -1 is returned.
+func (w *wrapper) CurrentSegment() (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.currentSegment()
+}
+
+// HeadSize returns the size in bytes of the segment currently being written to, that is, the highest numbered segment
+// in the WAL directory, which is useful to tune Config.SegmentSize. If there are no segments, 0 is returned.
+func (w *wrapper) HeadSize() (int64, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.headSegmentSize()
+}
+
+// currentSegment is CurrentSegment, but must be called with mtx held.
+func (w *wrapper) currentSegment() (int, error) {
+	segments, err := w.segments()
+	if err != nil {
+		return -1, err
+	}
+	if len(segments) == 0 {
+		return -1, nil
+	}
+	return segments[len(segments)-1], nil
+}
+
+// Repair reads the whole WAL, and if a corrupted record is found, trims the corrupted segment up to the last valid
+// record before the corruption. Note that, as done by wlog, all segments after the corrupted one are removed. If no
+// corruption is found, nil is returned without modifying the WAL.
+func (w *wrapper) Repair() error {
+	if err := w.checkWrite(); err != nil {
+		return err
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.checkOpen(); err != nil {
+		return err
+	}
+	return w.repair()
+}
+
+// repair is Repair, but must be called with mtx held.
+func (w *wrapper) repair() error {
+	segments, err := wlog.NewSegmentsReader(w.wal.Dir())
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segments: %w", err)
+	}
+	reader := wlog.NewReader(segments)
+	for reader.Next() {
+	}
+	_ = segments.Close()
+	corruption := reader.Err()
+	if corruption == nil {
+		return nil
+	}
+	level.Warn(w.log).Log("msg", "found corrupted WAL, repairing", "err", corruption)
+	defer w.invalidateStatCache()
+	return w.wal.Repair(corruption)
 }
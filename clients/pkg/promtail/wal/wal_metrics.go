@@ -0,0 +1,273 @@
+package wal
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type walMetrics struct {
+	recordsLogged *prometheus.CounterVec
+	bytesLogged   *prometheus.CounterVec
+	dryRunBytes   *prometheus.CounterVec
+
+	segmentsCreated *prometheus.CounterVec
+	segmentsEvicted *prometheus.CounterVec
+	mirrorErrors    *prometheus.CounterVec
+	logErrors       *prometheus.CounterVec
+	replayedRecords *prometheus.CounterVec
+	diskFull        *prometheus.CounterVec
+	staleSkipped    *prometheus.CounterVec
+	corruptions     *prometheus.CounterVec
+
+	lastWriteTimestamp *prometheus.GaugeVec
+	diskSize           *prometheus.GaugeVec
+	replayProgress     *prometheus.GaugeVec
+	openReaders        *prometheus.GaugeVec
+	lastCorruption     *prometheus.GaugeVec
+	oldestSegmentAge   *segmentAgeCollector
+
+	replayDuration *prometheus.HistogramVec
+	openDuration   *prometheus.HistogramVec
+}
+
+func newWALMetrics(reg prometheus.Registerer) *walMetrics {
+	m := &walMetrics{
+		recordsLogged: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "records_logged_total",
+				Help:      "Number of records logged to the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		bytesLogged: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "bytes_logged_total",
+				Help:      "Number of encoded bytes logged to the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		dryRunBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "dry_run_bytes_total",
+				Help:      "Number of encoded bytes that would have been logged to the WAL, when running in dry run mode.",
+			},
+			[]string{"client", "tenant"},
+		),
+		segmentsCreated: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "segments_created_total",
+				Help:      "Number of segments created by rotating the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		segmentsEvicted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "segments_evicted_total",
+				Help:      "Number of segments evicted to keep the WAL under its max size.",
+			},
+			[]string{"client", "tenant"},
+		),
+		mirrorErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "mirror_errors_total",
+				Help:      "Number of operations that failed on the mirror WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		logErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "log_errors_total",
+				Help:      "Number of failed attempts to log records to the WAL, by reason.",
+			},
+			[]string{"client", "tenant", "reason"},
+		),
+		replayedRecords: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "replayed_records_total",
+				Help:      "Number of records read from the WAL by replays.",
+			},
+			[]string{"client", "tenant"},
+		),
+		diskFull: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "disk_full_total",
+				Help:      "Number of writes to the WAL that failed because the disk was full.",
+			},
+			[]string{"client", "tenant"},
+		),
+		staleSkipped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "stale_records_skipped_total",
+				Help:      "Number of records skipped by replays for being older than the max record age.",
+			},
+			[]string{"client", "tenant"},
+		),
+		corruptions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "corruptions_total",
+				Help:      "Number of corrupted segments skipped by replays of the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		lastWriteTimestamp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "last_write_timestamp_seconds",
+				Help:      "Unix timestamp of the last record successfully logged to the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		diskSize: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "disk_size_bytes",
+				Help:      "Total size in bytes of the WAL segments on disk, updated periodically after writes.",
+			},
+			[]string{"client", "tenant"},
+		),
+		replayProgress: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "replay_progress_segments",
+				Help:      "Number of segments read so far by the ongoing, or last, replay of the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		openReaders: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "open_readers",
+				Help:      "Number of record readers and watches currently open on the WAL.",
+			},
+			[]string{"client", "tenant"},
+		),
+		lastCorruption: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "last_corruption_timestamp_seconds",
+				Help:      "Unix timestamp of the last time a replay of the WAL skipped a corrupted segment.",
+			},
+			[]string{"client", "tenant"},
+		),
+		oldestSegmentAge: newSegmentAgeCollector(),
+		replayDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "replay_duration_seconds",
+				Help:      "Duration of the WAL replays that read at least one record.",
+				Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+			},
+			[]string{"client", "tenant"},
+		),
+		openDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "promtail",
+				Subsystem: "wal",
+				Name:      "open_duration_seconds",
+				Help:      "Duration of opening the WAL, including the initial scan of its segments.",
+				Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+			},
+			[]string{"client", "tenant"},
+		),
+	}
+
+	if reg != nil {
+		m.recordsLogged = mustRegisterOrGet(reg, m.recordsLogged).(*prometheus.CounterVec)
+		m.bytesLogged = mustRegisterOrGet(reg, m.bytesLogged).(*prometheus.CounterVec)
+		m.dryRunBytes = mustRegisterOrGet(reg, m.dryRunBytes).(*prometheus.CounterVec)
+		m.segmentsCreated = mustRegisterOrGet(reg, m.segmentsCreated).(*prometheus.CounterVec)
+		m.segmentsEvicted = mustRegisterOrGet(reg, m.segmentsEvicted).(*prometheus.CounterVec)
+		m.mirrorErrors = mustRegisterOrGet(reg, m.mirrorErrors).(*prometheus.CounterVec)
+		m.logErrors = mustRegisterOrGet(reg, m.logErrors).(*prometheus.CounterVec)
+		m.replayedRecords = mustRegisterOrGet(reg, m.replayedRecords).(*prometheus.CounterVec)
+		m.diskFull = mustRegisterOrGet(reg, m.diskFull).(*prometheus.CounterVec)
+		m.staleSkipped = mustRegisterOrGet(reg, m.staleSkipped).(*prometheus.CounterVec)
+		m.corruptions = mustRegisterOrGet(reg, m.corruptions).(*prometheus.CounterVec)
+		m.lastWriteTimestamp = mustRegisterOrGet(reg, m.lastWriteTimestamp).(*prometheus.GaugeVec)
+		m.diskSize = mustRegisterOrGet(reg, m.diskSize).(*prometheus.GaugeVec)
+		m.replayProgress = mustRegisterOrGet(reg, m.replayProgress).(*prometheus.GaugeVec)
+		m.openReaders = mustRegisterOrGet(reg, m.openReaders).(*prometheus.GaugeVec)
+		m.lastCorruption = mustRegisterOrGet(reg, m.lastCorruption).(*prometheus.GaugeVec)
+		m.oldestSegmentAge = mustRegisterOrGet(reg, m.oldestSegmentAge).(*segmentAgeCollector)
+		m.replayDuration = mustRegisterOrGet(reg, m.replayDuration).(*prometheus.HistogramVec)
+		m.openDuration = mustRegisterOrGet(reg, m.openDuration).(*prometheus.HistogramVec)
+	}
+
+	return m
+}
+
+// mustRegisterOrGet registers c, returning the already registered collector if an equal one exists. This allows
+// multiple WALs to be created over the same registerer.
+func mustRegisterOrGet(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+// trackingRegisterer records the collectors registered through it, so that they can be unregistered when the wlog.WL
+// that registered them is replaced.
+type trackingRegisterer struct {
+	prometheus.Registerer
+	collectors []prometheus.Collector
+}
+
+func (r *trackingRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *trackingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// unregisterAll unregisters all collectors registered so far.
+func (r *trackingRegisterer) unregisterAll() {
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
+
+// registerer returns r as a prometheus.Registerer, or nil if r is nil.
+func (r *trackingRegisterer) registerer() prometheus.Registerer {
+	if r == nil {
+		return nil
+	}
+	return r
+}
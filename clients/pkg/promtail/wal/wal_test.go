@@ -0,0 +1,1556 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// newTestRecord builds a record with a single series, identified by ref, holding the given lines.
+func newTestRecord(ref uint64, lines ...string) *wal.Record {
+	rec := &wal.Record{
+		Series: []record.RefSeries{
+			{
+				Ref:    chunks.HeadSeriesRef(ref),
+				Labels: labels.FromStrings("test", fmt.Sprintf("series-%d", ref)),
+			},
+		},
+	}
+	entries := wal.RefEntries{Ref: chunks.HeadSeriesRef(ref)}
+	for _, line := range lines {
+		entries.Entries = append(entries.Entries, logproto.Entry{
+			Timestamp: time.Unix(0, int64(len(entries.Entries))),
+			Line:      line,
+		})
+	}
+	rec.RefEntries = append(rec.RefEntries, entries)
+	return rec
+}
+
+// requireLog logs rec to w, failing the test on error.
+func requireLog(t *testing.T, w WAL, rec *wal.Record) {
+	t.Helper()
+	_, err := w.Log(rec)
+	require.NoError(t, err)
+}
+
+func TestWAL_CustomSegmentSize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// write ~1.5MB to the WAL, which should make it rotate once a segment hits 1MB
+	line := strings.Repeat("a", 1024)
+	for i := 0; i < 1500; i++ {
+		requireLog(t, w, newTestRecord(1, line))
+	}
+	require.NoError(t, w.Sync())
+
+	first, err := os.Stat(filepath.Join(dir, "00000000"))
+	require.NoError(t, err)
+	require.LessOrEqual(t, first.Size(), int64(minSegmentSize))
+	_, err = os.Stat(filepath.Join(dir, "00000001"))
+	require.NoError(t, err, "expected wal to rotate into a second segment")
+}
+
+func TestWAL_InvalidSegmentSize(t *testing.T) {
+	_, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: 1024}, log.NewNopLogger(), nil)
+	require.Error(t, err)
+}
+
+func TestWAL_Compression(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compression=%t", compression), func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := New(Config{Enabled: true, Dir: dir, Compression: compression}, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+
+			lines := []string{"first line", "second line", strings.Repeat("repetitive ", 100)}
+			for i, line := range lines {
+				requireLog(t, w, newTestRecord(uint64(i), line))
+			}
+			w.Close()
+
+			entries, err := ReadWAL(dir)
+			require.NoError(t, err)
+			require.Len(t, entries, len(lines))
+			for i, entry := range entries {
+				require.Equal(t, lines[i], entry.Line)
+				require.Equal(t, fmt.Sprintf("series-%d", i), string(entry.Labels["test"]))
+			}
+		})
+	}
+}
+
+func TestWAL_Replay(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+
+	lines := []string{"first", "second", "third"}
+	for i, line := range lines {
+		requireLog(t, w, newTestRecord(uint64(i), line))
+	}
+	w.Close()
+
+	w, err = New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var series []uint64
+	var replayed []string
+	require.NoError(t, w.Replay(func(rec *wal.Record) error {
+		for _, s := range rec.Series {
+			series = append(series, uint64(s.Ref))
+		}
+		for _, entries := range rec.RefEntries {
+			for _, e := range entries.Entries {
+				replayed = append(replayed, e.Line)
+			}
+		}
+		return nil
+	}))
+	require.Equal(t, []uint64{0, 1, 2}, series)
+	require.Equal(t, lines, replayed)
+}
+
+func TestWAL_Disabled(t *testing.T) {
+	w, err := New(Config{Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.IsType(t, noopWAL{}, w)
+	require.True(t, IsNoop(w))
+	require.Equal(t, NoopDir, w.Dir())
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.NoError(t, w.Replay(func(*wal.Record) error {
+		t.Fatal("noop wal should not replay records")
+		return nil
+	}))
+
+	w, err = New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	require.False(t, IsNoop(w))
+}
+
+// errorWL fails all writes with err.
+type errorWL struct {
+	writeLog
+	err error
+}
+
+func (e errorWL) Log(...[]byte) error {
+	return e.err
+}
+
+// failingEncoder is an Encoder failing to encode any record.
+type failingEncoder struct {
+	DefaultEncoder
+}
+
+func (failingEncoder) Encode(*wal.Record) ([][]byte, error) {
+	return nil, errors.New("can't encode")
+}
+
+func TestWAL_LogErrorsMetric(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    Config
+		err    error
+		record *wal.Record
+		reason string
+	}{
+		{name: "write", err: errors.New("disk failure"), reason: logErrorWrite},
+		{name: "disk full", err: &os.PathError{Op: "write", Path: "00000000", Err: syscall.ENOSPC}, reason: logErrorDiskFull},
+		{name: "too large", cfg: Config{SegmentSize: minSegmentSize}, record: newTestRecord(1, strings.Repeat("a", minSegmentSize)), reason: logErrorTooLarge},
+		{name: "encode", cfg: Config{Encoder: failingEncoder{}}, reason: logErrorEncode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.Enabled, tc.cfg.Dir = true, t.TempDir()
+			w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), tc.cfg, "client", "tenant")
+			require.NoError(t, err)
+			defer w.Close()
+			if tc.err != nil {
+				injectWL(w, func(wl writeLog) errorWL { return errorWL{writeLog: wl, err: tc.err} })
+			}
+			if tc.record == nil {
+				tc.record = newTestRecord(1, "line")
+			}
+
+			_, err = w.Log(tc.record)
+			require.Error(t, err)
+			require.Error(t, w.LogBatch([]*wal.Record{tc.record}))
+
+			logErrors := w.(*wrapper).metrics.logErrors
+			require.Equal(t, 2.0, testutil.ToFloat64(logErrors.WithLabelValues("client", "tenant", tc.reason)))
+			require.Equal(t, 1, testutil.CollectAndCount(logErrors))
+		})
+	}
+}
+
+func TestWAL_LoggedMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	w, err := newWAL(log.NewNopLogger(), reg, Config{Enabled: true, Dir: t.TempDir()}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+
+	var expectedBytes int
+	for i := 0; i < 3; i++ {
+		rec := newTestRecord(uint64(i), "line")
+		expectedBytes += len(rec.EncodeSeries(nil)) + len(rec.EncodeEntries(wal.CurrentEntriesRec, nil))
+		requireLog(t, w, rec)
+	}
+	// series-only records are written as a single record
+	seriesOnly := newTestRecord(3)
+	seriesOnly.RefEntries = nil
+	expectedBytes += len(seriesOnly.EncodeSeries(nil))
+	requireLog(t, w, seriesOnly)
+
+	m := w.(*wrapper).metrics
+	require.Equal(t, 7.0, testutil.ToFloat64(m.recordsLogged.WithLabelValues("client", "tenant")))
+	require.Equal(t, float64(expectedBytes), testutil.ToFloat64(m.bytesLogged.WithLabelValues("client", "tenant")))
+
+	// metrics are shared between WALs created over the same registerer
+	require.Same(t, m.recordsLogged, newWALMetrics(reg).recordsLogged)
+}
+
+func TestWAL_LogReturnsWrittenBytes(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	rec := newTestRecord(1, "some line", "some other line")
+	n, err := w.Log(rec)
+	require.NoError(t, err)
+	require.Equal(t, len(rec.EncodeSeries(nil))+len(rec.EncodeEntries(wal.CurrentEntriesRec, nil)), n)
+
+	entriesOnly := newTestRecord(1, "entries only")
+	entriesOnly.Series = nil
+	n, err = w.Log(entriesOnly)
+	require.NoError(t, err)
+	require.Equal(t, len(entriesOnly.EncodeEntries(wal.CurrentEntriesRec, nil)), n)
+
+	n, err = w.Log(&wal.Record{})
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	n, err = noopWAL{}.Log(rec)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestWAL_Size(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	// non-segment files should not be accounted
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "not-a-segment"), make([]byte, 1024), 0o644))
+
+	line := strings.Repeat("a", 1024)
+	for i := 0; i < 2500; i++ {
+		requireLog(t, w, newTestRecord(1, line))
+	}
+	require.NoError(t, w.Sync())
+	_, err = os.Stat(filepath.Join(dir, "00000002"))
+	require.NoError(t, err, "expected wal to span three segments")
+
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.Greater(t, size, int64(2*minSegmentSize))
+	require.LessOrEqual(t, size, int64(3*minSegmentSize))
+
+	size, err = noopWAL{}.Size()
+	require.NoError(t, err)
+	require.Zero(t, size)
+}
+
+func TestWAL_DirLayout(t *testing.T) {
+	base := t.TempDir()
+	flat := func(base, clientName, tenantID string) string {
+		return filepath.Join(base, clientName+"-"+tenantID)
+	}
+
+	for name, tc := range map[string]struct {
+		dirFunc  func(base, clientName, tenantID string) string
+		expected string
+		err      bool
+	}{
+		"default layout": {
+			expected: filepath.Join(base, "client", "tenant"),
+		},
+		"custom flat layout": {
+			dirFunc:  flat,
+			expected: filepath.Join(base, "client-tenant"),
+		},
+		"empty dir": {
+			dirFunc: func(string, string, string) string { return "" },
+			err:     true,
+		},
+		"dir outside base": {
+			dirFunc: func(base, _, _ string) string { return filepath.Join(base, "..", "elsewhere") },
+			err:     true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: base, DirFunc: tc.dirFunc}, "client", "tenant")
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer w.Close()
+			require.Equal(t, tc.expected, w.Dir())
+		})
+	}
+}
+
+func TestWAL_UnsafeDirNames(t *testing.T) {
+	base := t.TempDir()
+	for name, tc := range map[string]struct {
+		clientName, tenantID string
+		err                  string
+	}{
+		"tenant path traversal": {clientName: "client", tenantID: "../../etc", err: `invalid WAL tenant ID "../../etc": must not contain path separators`},
+		"tenant parent dir":     {clientName: "client", tenantID: "..", err: "must not be a relative path element"},
+		"tenant current dir":    {clientName: "client", tenantID: ".", err: "must not be a relative path element"},
+		"tenant subdir":         {clientName: "client", tenantID: "a/b", err: "must not contain path separators"},
+		"tenant backslash":      {clientName: "client", tenantID: `a\b`, err: "must not contain path separators"},
+		"tenant null byte":      {clientName: "client", tenantID: "a\x00b", err: "must not contain null bytes"},
+		"client path traversal": {clientName: "../client", tenantID: "tenant", err: "invalid WAL client name"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: base}, tc.clientName, tc.tenantID)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+	// nothing was created outside, nor under, the base directory
+	entries, err := os.ReadDir(base)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// dots are fine within names
+	w, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: base}, "client", "tenant..1")
+	require.NoError(t, err)
+	defer w.Close()
+	require.Equal(t, filepath.Join(base, "client", "tenant..1"), w.Dir())
+}
+
+// newSegments creates a WAL in dir with count segments, logging a record in each.
+func newSegments(t *testing.T, dir string, count int) WAL {
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	for i := 0; i < count; i++ {
+		requireLog(t, w, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+		if i < count-1 {
+			_, err := w.NextSegment()
+			require.NoError(t, err)
+		}
+	}
+	return w
+}
+
+// segmentNumbers returns the numbers of the segments found in dir.
+func segmentNumbers(t *testing.T, dir string) []int {
+	segments, err := readSegmentRefs(OSFS{}, dir)
+	require.NoError(t, err)
+	numbers := []int{}
+	for _, s := range segments {
+		numbers = append(numbers, s.number)
+	}
+	return numbers
+}
+
+func TestWAL_DeleteSegment(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	defer w.Close()
+
+	require.NoError(t, w.DeleteSegment(1))
+	require.Equal(t, []int{0, 2}, segmentNumbers(t, dir))
+	require.EqualError(t, w.DeleteSegment(1), "delete segment 1: segment not found")
+}
+
+func TestWAL_DeleteSegmentNotPadded(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 2)
+	defer w.Close()
+	// segments not named as wlog does are found by scanning the directory
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "7"), nil, 0o644))
+
+	require.NoError(t, w.DeleteSegment(7))
+	require.Equal(t, []int{0, 1}, segmentNumbers(t, dir))
+	require.EqualError(t, w.DeleteSegment(7), "delete segment 7: segment not found")
+}
+
+func BenchmarkWAL_DeleteSegment(b *testing.B) {
+	dir := b.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(b, err)
+	defer w.Close()
+	// deleting should not get slower as the directory grows
+	for i := 1; i <= 1000; i++ {
+		require.NoError(b, os.WriteFile(wlog.SegmentName(dir, i), nil, 0o644))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		require.NoError(b, os.WriteFile(wlog.SegmentName(dir, 1), nil, 0o644))
+		b.StartTimer()
+		require.NoError(b, w.DeleteSegment(1))
+	}
+}
+
+func TestWAL_Truncate(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 6)
+	defer w.Close()
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5}, segmentNumbers(t, dir))
+
+	// truncating should be safe even if intermediate segments are already gone
+	require.NoError(t, w.DeleteSegment(1))
+	require.NoError(t, w.Truncate(3))
+	require.Equal(t, []int{3, 4, 5}, segmentNumbers(t, dir))
+
+	// the head segment is never removed
+	require.NoError(t, w.Truncate(10))
+	require.Equal(t, []int{5}, segmentNumbers(t, dir))
+}
+
+func BenchmarkWAL_LogLargeRecords(b *testing.B) {
+	rec := newTestRecord(1)
+	for i := 0; i < 64; i++ {
+		rec.RefEntries[0].Entries = append(rec.RefEntries[0].Entries, logproto.Entry{
+			Timestamp: time.Unix(0, int64(i)),
+			Line:      strings.Repeat("a", 512),
+		})
+	}
+
+	for name, bufferSize := range map[string]int{
+		"default pool": 0,
+		"tuned pool":   64 << 10,
+	} {
+		b.Run(name, func(b *testing.B) {
+			w, err := New(Config{Enabled: true, Dir: b.TempDir(), RecordBufferSize: bufferSize}, log.NewNopLogger(), nil)
+			require.NoError(b, err)
+			defer w.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.Log(rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWAL_IsolatedPools(b *testing.B) {
+	large := newTestRecord(1)
+	for i := 0; i < 64; i++ {
+		large.RefEntries[0].Entries = append(large.RefEntries[0].Entries, logproto.Entry{
+			Timestamp: time.Unix(0, int64(i)),
+			Line:      strings.Repeat("a", 512),
+		})
+	}
+	small := newTestRecord(2, "line")
+
+	for name, shared := range map[string]bool{
+		"isolated pools": false,
+		"shared pool":    true,
+	} {
+		b.Run(name, func(b *testing.B) {
+			highVolume, err := New(Config{Enabled: true, Dir: b.TempDir(), RecordBufferSize: 64 << 10}, log.NewNopLogger(), nil)
+			require.NoError(b, err)
+			defer highVolume.Close()
+			lowVolume, err := New(Config{Enabled: true, Dir: b.TempDir()}, log.NewNopLogger(), nil)
+			require.NoError(b, err)
+			defer lowVolume.Close()
+			if shared {
+				lowVolume.(*wrapper).pool = highVolume.(*wrapper).pool
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := highVolume.Log(large); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := lowVolume.Log(small); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWAL_LogAll(t *testing.T) {
+	var records []*wal.Record
+	for i := 0; i < 10; i++ {
+		records = append(records, newTestRecord(uint64(i), fmt.Sprintf("line %d", i)))
+	}
+	// records with only series or entries, or with neither, are handled as Log does
+	records = append(records, &wal.Record{Series: records[0].Series}, &wal.Record{RefEntries: records[1].RefEntries}, &wal.Record{})
+
+	logged, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	for _, rec := range records {
+		requireLog(t, logged, rec)
+	}
+	require.NoError(t, logged.Close())
+
+	dir := t.TempDir()
+	loggedAll, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, loggedAll.(Extended).LogAll(records))
+	require.NoError(t, loggedAll.Close())
+
+	expected, err := os.ReadFile(filepath.Join(logged.Dir(), "00000000"))
+	require.NoError(t, err)
+	actual, err := os.ReadFile(filepath.Join(dir, "00000000"))
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	t.Run("stops at the first error", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		tooLarge := newTestRecord(2, strings.Repeat("a", minSegmentSize))
+		err = w.(Extended).LogAll([]*wal.Record{newTestRecord(1, "first"), tooLarge, newTestRecord(3, "third")})
+		require.ErrorIs(t, err, ErrRecordTooLarge)
+		require.NoError(t, w.Close())
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"first"}, lines)
+	})
+}
+
+func BenchmarkWAL_LogAll(b *testing.B) {
+	var records []*wal.Record
+	for i := 0; i < 100; i++ {
+		records = append(records, newTestRecord(uint64(i), "line"))
+	}
+
+	for name, logAll := range map[string]func(w WAL) error{
+		"log loop": func(w WAL) error {
+			for _, rec := range records {
+				if _, err := w.Log(rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"log all": func(w WAL) error {
+			return w.(Extended).LogAll(records)
+		},
+	} {
+		b.Run(name, func(b *testing.B) {
+			w, err := New(Config{Enabled: true, Dir: b.TempDir()}, log.NewNopLogger(), nil)
+			require.NoError(b, err)
+			defer w.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := logAll(w); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWAL_RecordPool(t *testing.T) {
+	w1, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w1.Close()
+	w2, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w2.Close()
+	require.NotSame(t, w1.(*wrapper).pool, w2.(*wrapper).pool)
+
+	rec := w1.(Extended).GetRecord()
+	rec.UserID = "tenant"
+	requireLog(t, w1, rec)
+	w1.(Extended).PutRecord(rec)
+	require.Empty(t, w1.(Extended).GetRecord().UserID, "records from the pool should be reset")
+}
+
+func TestWAL_ConcurrentLogAndDeleteSegments(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(ref uint64) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := w.Log(newTestRecord(ref, "line"))
+				require.NoError(t, err)
+			}
+		}(uint64(i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			segment, err := w.NextSegment()
+			require.NoError(t, err)
+			require.NoError(t, w.DeleteSegment(segment-1))
+			_, err = w.Size()
+			require.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+
+	require.Equal(t, []int{20}, segmentNumbers(t, w.Dir()))
+}
+
+func TestWAL_CurrentSegment(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 3)
+	defer w.Close()
+
+	current, err := w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, 2, current)
+
+	// with no segments left, the sentinel is returned
+	for _, segment := range segmentNumbers(t, dir) {
+		require.NoError(t, w.DeleteSegment(segment))
+	}
+	current, err = w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, -1, current)
+}
+
+// slowSyncWL is a writeLog whose Sync blocks until release is closed.
+type slowSyncWL struct {
+	writeLog
+	release chan struct{}
+}
+
+func (s *slowSyncWL) Sync() error {
+	<-s.release
+	return nil
+}
+
+func TestWAL_SyncContext(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	slow := &slowSyncWL{writeLog: w.(*wrapper).wal, release: make(chan struct{})}
+	defer close(slow.release)
+	w.(*wrapper).wal = slow
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.ErrorIs(t, w.SyncContext(ctx), context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// an already expired context doesn't attempt to sync at all
+	require.ErrorIs(t, w.SyncContext(ctx), context.DeadlineExceeded)
+}
+
+func TestWAL_SegmentsCreatedMetric(t *testing.T) {
+	w, err := newWAL(log.NewNopLogger(), prometheus.NewRegistry(), Config{Enabled: true, Dir: t.TempDir()}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := w.NextSegment()
+		require.NoError(t, err)
+	}
+	created := w.(*wrapper).metrics.segmentsCreated.WithLabelValues("client", "tenant")
+	require.Equal(t, 3.0, testutil.ToFloat64(created))
+}
+
+// recordingWL is a writeLog that records the Sync and Close calls made to it.
+type recordingWL struct {
+	writeLog
+	mtx   sync.Mutex
+	calls []string
+}
+
+func (r *recordingWL) record(call string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recordingWL) Sync() error {
+	r.record("sync")
+	return r.writeLog.Sync()
+}
+
+func (r *recordingWL) Close() error {
+	r.record("close")
+	return r.writeLog.Close()
+}
+
+func (r *recordingWL) count(call string) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var n int
+	for _, c := range r.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+// injectWL replaces the writeLog underneath w with the one built by wrap.
+func injectWL[T writeLog](w WAL, wrap func(writeLog) T) T {
+	wr := w.(*wrapper)
+	wr.mtx.Lock()
+	defer wr.mtx.Unlock()
+	injected := wrap(wr.wal)
+	wr.wal = injected
+	return injected
+}
+
+func TestWAL_SyncModes(t *testing.T) {
+	t.Run("per record", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), SyncMode: SyncModePerRecord}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		rec := injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} })
+
+		requireLog(t, w, newTestRecord(1, "line"))
+		requireLog(t, w, newTestRecord(1, "line"))
+		require.Equal(t, 2, rec.count("sync"))
+	})
+
+	t.Run("manual", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), SyncMode: SyncModeManual}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		rec := injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} })
+
+		requireLog(t, w, newTestRecord(1, "line"))
+		w.Close()
+		require.Equal(t, []string{"close"}, rec.calls)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), SyncMode: SyncModeInterval, SyncInterval: 10 * time.Millisecond}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		rec := injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} })
+
+		requireLog(t, w, newTestRecord(1, "line"))
+		require.Eventually(t, func() bool {
+			return rec.count("sync") >= 2
+		}, time.Second, 5*time.Millisecond, "expected background sync to fire")
+
+		// closing flushes once more before closing the underlying WAL, only once
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+		require.Equal(t, []string{"sync", "close"}, rec.calls[len(rec.calls)-2:])
+	})
+}
+
+func TestWAL_RecordTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir, SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	_, err = w.Log(newTestRecord(1, strings.Repeat("a", 2*minSegmentSize)))
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+	require.Equal(t, []int{0}, segmentNumbers(t, dir), "no segment should have been created for the record")
+
+	// smaller records are still accepted
+	requireLog(t, w, newTestRecord(1, "line"))
+}
+
+func TestWAL_Reopen(t *testing.T) {
+	t.Run("empty directory", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		require.Equal(t, 0, w.(Extended).StartSegment())
+	})
+
+	t.Run("existing segments", func(t *testing.T) {
+		dir := t.TempDir()
+		newSegments(t, dir, 3).Close()
+
+		w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		require.Equal(t, 3, w.(Extended).StartSegment())
+		requireLog(t, w, newTestRecord(3, "line 3"))
+		w.Close()
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"line 0", "line 1", "line 2", "line 3"}, lines)
+	})
+
+	t.Run("existing segments with gaps", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newSegments(t, dir, 4)
+		require.NoError(t, w.DeleteSegment(1))
+		require.NoError(t, w.DeleteSegment(2))
+		w.Close()
+
+		w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		require.Equal(t, 4, w.(Extended).StartSegment())
+		require.Equal(t, []int{0, 1, 2, 3, 4}, segmentNumbers(t, dir))
+		requireLog(t, w, newTestRecord(4, "line 4"))
+		w.Close()
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"line 0", "line 3", "line 4"}, lines)
+	})
+}
+
+func TestWAL_LoggerFields(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, filepath.Join(dir, "client", "tenant"), 3)
+	require.NoError(t, w.DeleteSegment(1))
+	w.Close()
+
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(&buf))
+	// reopening a WAL with gaps will log a warning per filled segment
+	w, err := newWAL(logger, prometheus.NewRegistry(), Config{Enabled: true, Dir: dir}, "client", "tenant")
+	require.NoError(t, err)
+	w.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.NotEmpty(t, lines)
+	for _, line := range lines {
+		require.Contains(t, line, "client=client tenant=tenant component=wal")
+	}
+}
+
+func TestWAL_LastWriteTime(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	w, err := newWAL(log.NewNopLogger(), reg, Config{Enabled: true, Dir: t.TempDir()}, "client", "tenant")
+	require.NoError(t, err)
+	defer w.Close()
+	require.True(t, w.LastWriteTime().IsZero())
+
+	before := time.Now()
+	requireLog(t, w, newTestRecord(1, "line"))
+	lastWrite := w.LastWriteTime()
+	require.False(t, lastWrite.Before(before))
+	require.WithinDuration(t, time.Now(), lastWrite, time.Second)
+
+	gauge := testutil.ToFloat64(w.(*wrapper).metrics.lastWriteTimestamp.WithLabelValues("client", "tenant"))
+	require.Equal(t, float64(lastWrite.UnixNano())/1e9, gauge)
+
+	require.True(t, noopWAL{}.LastWriteTime().IsZero())
+}
+
+func TestWAL_CountSegments(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 4)
+	defer w.Close()
+	// files with non numeric names aren't segments
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checkpoint.tmp"), nil, 0o644))
+
+	count, err := w.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	require.NoError(t, w.DeleteSegment(0))
+	count, err = w.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	count, err = noopWAL{}.CountSegments()
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestWAL_Segments(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 4)
+	defer w.Close()
+	require.NoError(t, w.DeleteSegment(1))
+	// files with non numeric names aren't segments
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checkpoint.tmp"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, metaName(2)), nil, 0o644))
+	// segments are sorted by number, not by name
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "9"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000010"), nil, 0o644))
+
+	segments, err := w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 2, 3, 9, 10}, segments)
+	current, err := w.CurrentSegment()
+	require.NoError(t, err)
+	require.Equal(t, 10, current)
+
+	segments, err = noopWAL{}.Segments()
+	require.NoError(t, err)
+	require.Empty(t, segments)
+	require.NotNil(t, segments)
+}
+
+func TestWAL_HeadSize(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	size, err := w.HeadSize()
+	require.NoError(t, err)
+	require.Equal(t, int64(versionRecordSize), size)
+
+	expected := int64(versionRecordSize)
+	for i := 0; i < 3; i++ {
+		written, err := w.Log(newTestRecord(uint64(i), "line"))
+		require.NoError(t, err)
+		// series and entries are written as separate records, each with its own header
+		expected += int64(written + 2*recordHeaderSize)
+		size, err := w.HeadSize()
+		require.NoError(t, err)
+		require.Equal(t, expected, size)
+	}
+
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	size, err = w.HeadSize()
+	require.NoError(t, err)
+	require.Zero(t, size)
+
+	size, err = noopWAL{}.HeadSize()
+	require.NoError(t, err)
+	require.Zero(t, size)
+}
+
+// closeErrorWL closes the wrapped writeLog, but returns err.
+type closeErrorWL struct {
+	writeLog
+	err error
+}
+
+func (c closeErrorWL) Close() error {
+	_ = c.writeLog.Close()
+	return c.err
+}
+
+func TestWAL_Close(t *testing.T) {
+	t.Run("twice", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		requireLog(t, w, newTestRecord(1, "line"))
+
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+		_, err = w.Log(newTestRecord(1, "after close"))
+		require.Error(t, err)
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"line"}, lines)
+	})
+
+	t.Run("and delete", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Delete())
+		require.NoError(t, w.Close())
+		_, err = os.Stat(w.Dir())
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("delete after failing close", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		closeErr := errors.New("close failed")
+		injectWL(w, func(wl writeLog) closeErrorWL {
+			// the real wal is still closed, so that its directory can be removed
+			return closeErrorWL{writeLog: wl, err: closeErr}
+		})
+
+		err = w.Delete()
+		require.ErrorIs(t, err, closeErr)
+		require.ErrorContains(t, err, "failed to close WAL")
+		require.NotContains(t, err.Error(), "failed to remove")
+		_, err = os.Stat(w.Dir())
+		require.True(t, os.IsNotExist(err), "directory should be removed even if closing fails")
+	})
+
+	t.Run("noop", func(t *testing.T) {
+		require.NoError(t, noopWAL{}.Close())
+		require.NoError(t, noopWAL{}.Close())
+	})
+}
+
+func TestWAL_DirNotWritable(t *testing.T) {
+	t.Run("nonexistent parent is created", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing", "parent")
+		w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+		require.DirExists(t, dir)
+	})
+
+	t.Run("parent is a file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o644))
+		_, err := New(Config{Enabled: true, Dir: filepath.Join(file, "wal")}, log.NewNopLogger(), nil)
+		require.ErrorContains(t, err, "is not writable")
+	})
+
+	t.Run("read-only directory", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("permissions are not enforced for root")
+		}
+		dir := t.TempDir()
+		require.NoError(t, os.Chmod(dir, 0o555))
+		defer func() { _ = os.Chmod(dir, 0o755) }()
+		_, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+		require.ErrorContains(t, err, fmt.Sprintf("wal dir %q is not writable", dir))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "disabled")
+		_, err := New(Config{Dir: dir}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		require.NoDirExists(t, dir)
+	})
+}
+
+func TestWAL_Stats(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 4)
+	defer w.Close()
+	require.NoError(t, w.DeleteSegment(0))
+	require.NoError(t, w.Sync())
+
+	size, err := w.Size()
+	require.NoError(t, err)
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	require.Equal(t, Stats{
+		Size:          size,
+		Segments:      3,
+		OldestSegment: 1,
+		NewestSegment: 3,
+		LastWrite:     w.LastWriteTime(),
+		// each segment holds a series and an entries record
+		SegmentRecords: map[int]int{1: 2, 2: 2, 3: 2},
+	}, stats)
+	require.False(t, stats.LastWrite.IsZero())
+
+	stats, err = noopWAL{}.Stats()
+	require.NoError(t, err)
+	require.Equal(t, Stats{}, stats)
+}
+
+func TestWAL_Closed(t *testing.T) {
+	dir := t.TempDir()
+	w := newSegments(t, dir, 2)
+	require.NoError(t, w.Close())
+	// closing again is a no-op
+	require.NoError(t, w.Close())
+	wr := w.(Extended)
+
+	for name, op := range map[string]func() error{
+		"Log": func() error {
+			_, err := w.Log(newTestRecord(1, "line"))
+			return err
+		},
+		"LogTracked": func() error {
+			_, err := wr.LogTracked(newTestRecord(1, "line"))
+			return err
+		},
+		"LogBatch": func() error { return w.LogBatch([]*wal.Record{newTestRecord(1, "line")}) },
+		"LogAll":   func() error { return wr.LogAll([]*wal.Record{newTestRecord(1, "line")}) },
+		"Sync":     w.Sync,
+		"Flush":    wr.Flush,
+		"NextSegment": func() error {
+			_, err := w.NextSegment()
+			return err
+		},
+		"DeleteSegment": func() error { return w.DeleteSegment(0) },
+		"Truncate":      func() error { return w.Truncate(1) },
+		"DeleteOlderThan": func() error {
+			_, err := w.DeleteOlderThan(0)
+			return err
+		},
+		"Compact":          wr.Compact,
+		"Repair":           wr.Repair,
+		"WriteSegmentMeta": func() error { return wr.WriteSegmentMeta(0, []byte("meta")) },
+		"Drain":            func() error { return wr.Drain(func(*wal.Record) error { return nil }) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorIs(t, op(), ErrClosed)
+		})
+	}
+
+	// reads still work, and nothing was modified
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"line 0", "line 1"}, lines)
+	require.Equal(t, []int{0, 1}, segmentNumbers(t, dir))
+}
+
+func TestWAL_OpenDuration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	var logs bytes.Buffer
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewLogfmtLogger(log.NewSyncWriter(&logs)), reg)
+	require.NoError(t, err)
+	defer w.Close()
+	require.Equal(t, uint64(1), histogramSampleCount(t, reg, "promtail_wal_open_duration_seconds"))
+	require.NotContains(t, logs.String(), "opening WAL was slow")
+
+	// resetting opens the WAL again
+	require.NoError(t, w.(Extended).Reset())
+	require.Equal(t, uint64(2), histogramSampleCount(t, reg, "promtail_wal_open_duration_seconds"))
+
+	slow, err := New(Config{Enabled: true, Dir: t.TempDir(), SlowOpenThreshold: time.Nanosecond}, log.NewLogfmtLogger(log.NewSyncWriter(&logs)), nil)
+	require.NoError(t, err)
+	defer slow.Close()
+	require.Contains(t, logs.String(), "opening WAL was slow")
+}
+
+func TestWAL_StatsSegmentRecords(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	defer w.Close()
+	for i := 0; i < 10; i++ {
+		requireLog(t, w, newTestRecord(1, "line"))
+		// series only records, so that segments hold different counts
+		if i%3 == 0 {
+			requireLog(t, w, &wal.Record{Series: newTestRecord(2).Series})
+			_, err := w.NextSegment()
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, w.Sync())
+
+	sum := func(stats Stats) int {
+		var total int
+		for _, records := range stats.SegmentRecords {
+			total += records
+		}
+		return total
+	}
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	require.Len(t, stats.SegmentRecords, stats.Segments)
+	require.Equal(t, map[int]int{0: 3, 1: 7, 2: 7, 3: 7, 4: 0}, stats.SegmentRecords)
+	logged := testutil.ToFloat64(w.(*wrapper).metrics.recordsLogged.WithLabelValues("", ""))
+	require.Equal(t, int(logged), sum(stats))
+
+	// counts cached for closed segments are dropped with them, and the head is counted again
+	require.NoError(t, w.DeleteSegment(0))
+	requireLog(t, w, newTestRecord(1, "line"))
+	require.NoError(t, w.Sync())
+	stats, err = w.Stats()
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{1: 7, 2: 7, 3: 7, 4: 2}, stats.SegmentRecords)
+	require.Len(t, w.(*wrapper).recordCounts, 3)
+
+	// resetting a deleted WAL drops the cached counts of its segments
+	require.NoError(t, w.Delete())
+	require.NoError(t, w.(Extended).Reset())
+	require.Empty(t, w.(*wrapper).recordCounts)
+	stats, err = w.Stats()
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{0: 0, 1: 0}, stats.SegmentRecords)
+}
+
+func TestWAL_LogContext(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	written, err := w.(Extended).LogContext(ctx, newTestRecord(1, "line"))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Zero(t, written)
+	require.True(t, w.LastWriteTime().IsZero())
+	size, err := w.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(versionRecordSize), size)
+
+	written, err = w.(Extended).LogContext(context.Background(), newTestRecord(1, "line"))
+	require.NoError(t, err)
+	require.NotZero(t, written)
+}
+
+func TestWAL_Reset(t *testing.T) {
+	// registering the wlog metrics again would panic if the previous ones weren't unregistered
+	reg := prometheus.NewRegistry()
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), SyncMode: SyncModeInterval, SyncInterval: time.Millisecond}, log.NewNopLogger(), reg)
+	require.NoError(t, err)
+	defer w.Close()
+	requireLog(t, w, newTestRecord(1, "before delete"))
+	require.NoError(t, w.Delete())
+
+	require.NoError(t, w.(Extended).Reset())
+	requireLog(t, w, newTestRecord(2, "after reset"))
+	require.NoError(t, w.Close())
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"after reset"}, lines)
+
+	// resetting a closed WAL reopens it, keeping its segments
+	require.NoError(t, w.(Extended).Reset())
+	requireLog(t, w, newTestRecord(3, "after second reset"))
+	lines, err = replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"after reset", "after second reset"}, lines)
+	_, err = reg.Gather()
+	require.NoError(t, err)
+}
+
+func TestWAL_LogTracked(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), SegmentSize: minSegmentSize}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+
+	segment, err := ww.LogTracked(newTestRecord(1, "first"))
+	require.NoError(t, err)
+	require.Equal(t, 0, segment)
+
+	next, err := w.NextSegment()
+	require.NoError(t, err)
+	segment, err = ww.LogTracked(newTestRecord(2, "second"))
+	require.NoError(t, err)
+	require.Equal(t, next, segment)
+
+	// wlog rotates on its own once the segment is full
+	line := strings.Repeat("a", minSegmentSize/4)
+	for i := 0; i < 8; i++ {
+		segment, err = ww.LogTracked(newTestRecord(3, line))
+		require.NoError(t, err)
+		current, err := w.CurrentSegment()
+		require.NoError(t, err)
+		require.Equal(t, current, segment)
+	}
+	require.Greater(t, segment, next)
+}
+
+func TestWAL_LogHook(t *testing.T) {
+	hook := func(rec *wal.Record) (*wal.Record, bool) {
+		line := rec.RefEntries[0].Entries[0].Line
+		switch {
+		case line == "drop":
+			return nil, false
+		case strings.HasPrefix(line, "mutate "):
+			return newTestRecord(uint64(rec.RefEntries[0].Ref), strings.TrimPrefix(line, "mutate ")), true
+		}
+		return rec, true
+	}
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), LogHook: hook}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	requireLog(t, w, newTestRecord(1, "passthrough"))
+	written, err := w.Log(newTestRecord(2, "drop"))
+	require.NoError(t, err)
+	require.Zero(t, written)
+	requireLog(t, w, newTestRecord(3, "mutate mutated"))
+	segment, err := w.(Extended).LogTracked(newTestRecord(4, "drop"))
+	require.NoError(t, err)
+	require.Equal(t, -1, segment)
+	require.NoError(t, w.(Extended).LogAll([]*wal.Record{newTestRecord(5, "drop"), newTestRecord(6, "mutate all")}))
+	require.NoError(t, w.LogBatch([]*wal.Record{newTestRecord(7, "mutate batch"), newTestRecord(8, "drop")}))
+
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"passthrough", "mutated", "all", "batch"}, lines)
+}
+
+func TestWAL_StrictEmpty(t *testing.T) {
+	empty := &wal.Record{}
+	t.Run("disabled", func(t *testing.T) {
+		w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+		require.NoError(t, err)
+		defer w.Close()
+
+		written, err := w.Log(empty)
+		require.NoError(t, err)
+		require.Zero(t, written)
+		_, err = w.Log(nil)
+		require.NoError(t, err)
+		require.NoError(t, w.LogBatch([]*wal.Record{newTestRecord(1, "line"), empty}))
+	})
+	t.Run("enabled", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		w, err := New(Config{Enabled: true, Dir: t.TempDir(), StrictEmpty: true}, log.NewNopLogger(), reg)
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Log(empty)
+		require.ErrorIs(t, err, ErrEmptyRecord)
+		_, err = w.Log(nil)
+		require.ErrorIs(t, err, ErrEmptyRecord)
+		_, err = w.(Extended).LogTracked(empty)
+		require.ErrorIs(t, err, ErrEmptyRecord)
+		require.ErrorIs(t, w.(Extended).LogAll([]*wal.Record{newTestRecord(1, "all"), empty}), ErrEmptyRecord)
+		// the batch fails as a whole
+		require.ErrorIs(t, w.LogBatch([]*wal.Record{newTestRecord(2, "batch"), empty}), ErrEmptyRecord)
+		requireLog(t, w, newTestRecord(3, "line"))
+
+		lines, err := replayLines(w)
+		require.NoError(t, err)
+		require.Equal(t, []string{"all", "line"}, lines)
+		require.Equal(t, 5.0, testutil.ToFloat64(w.(*wrapper).metrics.logErrors.WithLabelValues("", "", logErrorEmpty)))
+	})
+}
+
+func TestWAL_Unwrap(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	wl := w.(WLUnwrapper).Unwrap()
+	require.NotNil(t, wl)
+	require.Equal(t, dir, wl.Dir())
+	requireLog(t, w, newTestRecord(1, "line"))
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	current, err := w.CurrentSegment()
+	require.NoError(t, err)
+	_, last, err := wlog.Segments(wl.Dir())
+	require.NoError(t, err)
+	require.Equal(t, current, last)
+
+	// Reset replaces the underlying WL
+	require.NoError(t, w.(Extended).Reset())
+	require.NotSame(t, wl, w.(WLUnwrapper).Unwrap())
+
+	noop, err := New(Config{}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.Nil(t, noop.(WLUnwrapper).Unwrap())
+}
+
+func TestWAL_Rotate(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir(), BufferSize: 1 << 20}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(Extended)
+	rec := injectWL(w, func(wl writeLog) *recordingWL { return &recordingWL{writeLog: wl} })
+
+	// the record is only buffered until the rotation
+	requireLog(t, w, newTestRecord(1, "before"))
+	prev, err := w.CurrentSegment()
+	require.NoError(t, err)
+	next, err := ww.Rotate()
+	require.NoError(t, err)
+	require.Equal(t, prev+1, next)
+	require.Equal(t, 1, rec.count("sync"))
+	stats, err := w.Stats()
+	require.NoError(t, err)
+	// the series and entries of the record
+	require.Equal(t, map[int]int{prev: 2, next: 0}, stats.SegmentRecords)
+
+	segment, err := ww.LogTracked(newTestRecord(2, "after"))
+	require.NoError(t, err)
+	require.Equal(t, next, segment)
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"before", "after"}, lines)
+}
+
+func TestWAL_DirInUse(t *testing.T) {
+	base := t.TempDir()
+	// a lossy escaping mapping different clients to the same directory
+	cfg := Config{Enabled: true, Dir: base, DirFunc: func(base, clientName, _ string) string {
+		return filepath.Join(base, strings.ReplaceAll(clientName, "/", "_"))
+	}}
+	first, err := newWAL(log.NewNopLogger(), nil, cfg, "a/b", "")
+	require.NoError(t, err)
+
+	_, err = newWAL(log.NewNopLogger(), nil, cfg, "a_b", "")
+	require.ErrorIs(t, err, ErrWALDirInUse)
+	// relative paths to the same directory collide too
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	rel, err := filepath.Rel(wd, first.Dir())
+	require.NoError(t, err)
+	_, err = New(Config{Enabled: true, Dir: rel}, log.NewNopLogger(), nil)
+	require.ErrorIs(t, err, ErrWALDirInUse)
+
+	// read-only WALs don't claim their directory
+	readOnly, err := New(Config{Enabled: true, Dir: first.Dir(), ReadOnly: true}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, readOnly.Close())
+
+	// the directory is released once closed
+	require.NoError(t, first.Close())
+	second, err := newWAL(log.NewNopLogger(), nil, cfg, "a_b", "")
+	require.NoError(t, err)
+	require.NoError(t, second.Close())
+}
+
+func TestWAL_EstimateSize(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"default":   {},
+		"checksums": {RecordChecksums: true},
+		"encoder":   {Encoder: DefaultEncoder{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Enabled, cfg.Dir = true, t.TempDir()
+			w, err := New(cfg, log.NewNopLogger(), nil)
+			require.NoError(t, err)
+			defer w.Close()
+			ww := w.(Extended)
+
+			for _, rec := range []*wal.Record{
+				newTestRecord(1, "first", "second"),
+				newTestRecord(2),
+				{RefEntries: newTestRecord(3, "entries only").RefEntries},
+			} {
+				headBefore, err := w.HeadSize()
+				require.NoError(t, err)
+				estimate, err := ww.EstimateSize(rec)
+				require.NoError(t, err)
+				// estimating writes nothing
+				head, err := w.HeadSize()
+				require.NoError(t, err)
+				require.Equal(t, headBefore, head)
+
+				written, err := w.Log(rec)
+				require.NoError(t, err)
+				require.Equal(t, written, estimate)
+			}
+			estimate, err := ww.EstimateSize(&wal.Record{})
+			require.NoError(t, err)
+			require.Zero(t, estimate)
+			_, err = ww.EstimateSize(newTestRecord(4, strings.Repeat("a", wlog.DefaultSegmentSize)))
+			require.ErrorIs(t, err, ErrRecordTooLarge)
+		})
+	}
+}
+
+func TestWAL_ResetRecreatesDirAtomically(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(Config{Enabled: true, Dir: dir}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	requireLog(t, w, newTestRecord(1, "before delete"))
+	require.NoError(t, w.Delete())
+
+	// the directory is either missing or holds a segment while resetting, it's never seen without segments, and it
+	// doesn't go missing again once recreated
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		var recreated bool
+		for {
+			segments, err := readSegmentRefs(OSFS{}, dir)
+			switch {
+			case os.IsNotExist(err):
+				if recreated {
+					errs <- fmt.Errorf("directory missing after being recreated")
+					return
+				}
+			case err != nil:
+				errs <- err
+				return
+			case len(segments) == 0:
+				errs <- fmt.Errorf("directory seen without segments")
+				return
+			default:
+				recreated = true
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+	require.NoError(t, w.(Extended).Reset())
+	close(done)
+	require.NoError(t, <-errs)
+	// the directory was moved into place holding a first segment with only the header, after which wlog opened its head
+	require.Equal(t, []int{0, 1}, segmentNumbers(t, dir))
+
+	requireLog(t, w, newTestRecord(2, "after reset"))
+	lines, err := replayLines(w)
+	require.NoError(t, err)
+	require.Equal(t, []string{"after reset"}, lines)
+	// no temporary directory is left behind
+	siblings, err := os.ReadDir(filepath.Dir(dir))
+	require.NoError(t, err)
+	for _, sibling := range siblings {
+		require.NotContains(t, sibling.Name(), ".reset")
+	}
+}
+
+func TestWAL_DebugLogs(t *testing.T) {
+	var logs bytes.Buffer
+	logger := level.NewFilter(log.NewLogfmtLogger(&logs), level.AllowDebug())
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, logger, nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	written, err := w.Log(newTestRecord(1, "line"))
+	require.NoError(t, err)
+	all := []*wal.Record{newTestRecord(2, "first"), newTestRecord(3, "second")}
+	var allWritten int
+	for _, rec := range all {
+		size, err := w.(Extended).EstimateSize(rec)
+		require.NoError(t, err)
+		allWritten += size
+	}
+	require.NoError(t, w.(Extended).LogAll(all))
+	require.NoError(t, w.Sync())
+	next, err := w.NextSegment()
+	require.NoError(t, err)
+	require.NoError(t, w.DeleteSegment(0))
+
+	for _, line := range []string{
+		fmt.Sprintf(`msg="logged record to WAL" bytes=%d`, written),
+		fmt.Sprintf(`msg="logged records to WAL" records=2 bytes=%d`, allWritten),
+		`msg="synced WAL"`,
+		fmt.Sprintf(`msg="rotated WAL to a new segment" segment=%d`, next),
+		`msg="removed WAL segment" segment=0`,
+	} {
+		require.Contains(t, logs.String(), line)
+	}
+
+	// debug lines are filtered out at the default level
+	logs.Reset()
+	quiet, err := New(Config{Enabled: true, Dir: t.TempDir()}, level.NewFilter(log.NewLogfmtLogger(&logs), level.AllowInfo()), nil)
+	require.NoError(t, err)
+	defer quiet.Close()
+	requireLog(t, quiet, newTestRecord(1, "line"))
+	require.NoError(t, quiet.Sync())
+	require.NotContains(t, logs.String(), "level=debug")
+}
@@ -0,0 +1,148 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// Watch tails the WAL, sending on the returned channel every record logged after it's called, in order, until ctx is
+// done, and then closing the channel. Like the Watcher, it polls the segment being read, and follows rotations
+// transparently, skipping segments deleted before being reached. If a segment can't be read, the error is logged and
+// the channel closed. A slow consumer holds back the watch, but never the writers. Until ctx is done, Truncate keeps the
+// segment being read.
+func (w *wrapper) Watch(ctx context.Context) (<-chan *wal.Record, error) {
+	// prevent writes until the records already in the head segment are skipped
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	// Reset replaces w.wal, so the watch routine reads the directory captured here instead, without locking
+	dir := w.wal.Dir()
+	segments, err := readSegmentRefs(w.fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading segments in wal directory: %w", err)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments to watch in wal directory")
+	}
+	if err := checkVersionFile(dir); err != nil {
+		return nil, err
+	}
+	segment, err := wlog.OpenReadSegment(wlog.SegmentName(dir, segments[len(segments)-1].number))
+	if err != nil {
+		return nil, err
+	}
+	reader := wlog.NewLiveReader(w.log, nil, segment)
+	for reader.Next() {
+	}
+	if err := reader.Err(); err != io.EOF {
+		_ = segment.Close()
+		return nil, fmt.Errorf("error reading wal segment %d: %w", segment.Index(), err)
+	}
+
+	records := make(chan *wal.Record)
+	readerID := w.readers.open(segment.Index())
+	go w.watch(ctx, dir, records, readerID, segment, reader)
+	return records, nil
+}
+
+func (w *wrapper) watch(ctx context.Context, dir string, records chan<- *wal.Record, readerID int, segment *wlog.Segment, reader *wlog.LiveReader) {
+	defer close(records)
+	defer w.readers.close(readerID)
+	defer func() {
+		_ = segment.Close()
+	}()
+
+	readTicker := w.clock.NewTicker(readPeriod)
+	defer readTicker.Stop()
+	segmentTicker := w.clock.NewTicker(segmentCheckPeriod)
+	defer segmentTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-readTicker.C():
+			if !w.sendRecords(ctx, records, segment.Index(), reader) {
+				return
+			}
+
+		case <-segmentTicker.C():
+			next, err := w.openSegmentAfter(dir, segment.Index())
+			if err != nil {
+				level.Warn(w.log).Log("msg", "stopping WAL watch, failed to open next segment", "err", err)
+				return
+			}
+			if next == nil {
+				continue
+			}
+			// the segment was rotated, so read what's left of it before moving to the next one
+			if !w.sendRecords(ctx, records, segment.Index(), reader) {
+				_ = next.Close()
+				return
+			}
+			_ = segment.Close()
+			segment, reader = next, wlog.NewLiveReader(w.log, nil, next)
+			w.readers.move(readerID, segment.Index())
+		}
+	}
+}
+
+// sendRecords decodes and sends all records available in reader. It returns false if ctx is done, or the segment can't
+// be read, in which case the watch should stop.
+func (w *wrapper) sendRecords(ctx context.Context, records chan<- *wal.Record, segmentNum int, reader *wlog.LiveReader) bool {
+	for reader.Next() {
+		isVersion, err := checkVersionRecord(reader.Record())
+		if err != nil {
+			level.Warn(w.log).Log("msg", "stopping WAL watch", "segment", segmentNum, "err", err)
+			return false
+		}
+		if isVersion {
+			continue
+		}
+		rec, err := decodeRecord(reader.Record(), &wal.Record{}, w.cfg.RecordChecksums, w.cfg.Encoder)
+		if err != nil {
+			level.Warn(w.log).Log("msg", "stopping WAL watch, failed to decode record", "segment", segmentNum, "offset", reader.Offset(), "err", err)
+			return false
+		}
+		select {
+		case records <- rec:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if err := reader.Err(); err != io.EOF {
+		level.Warn(w.log).Log("msg", "stopping WAL watch, failed to read segment", "segment", segmentNum, "offset", reader.Offset(), "err", err)
+		return false
+	}
+	return true
+}
+
+// openSegmentAfter opens the lowest numbered segment in dir after segmentNum, skipping segments deleted before they
+// could be opened. If there's none, nil is returned.
+func (w *wrapper) openSegmentAfter(dir string, segmentNum int) (*wlog.Segment, error) {
+	segments, err := readSegmentRefs(w.fs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading segments in wal directory: %w", err)
+	}
+	for _, segment := range segments {
+		if segment.number <= segmentNum {
+			continue
+		}
+		if err := checkVersionFile(dir); err != nil {
+			return nil, err
+		}
+		next, err := wlog.OpenReadSegment(wlog.SegmentName(dir, segment.number))
+		if os.IsNotExist(err) {
+			continue
+		}
+		return next, err
+	}
+	return nil, nil
+}
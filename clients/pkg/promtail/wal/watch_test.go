@@ -0,0 +1,157 @@
+package wal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/loki/pkg/ingester/wal"
+)
+
+// watchLines receives records until n entry lines are found, returning them. Series are logged in their own records,
+// which hold no lines.
+func watchLines(t *testing.T, records <-chan *wal.Record, n int) []string {
+	var lines []string
+	timeout := time.After(5 * time.Second)
+	for len(lines) < n {
+		select {
+		case rec := <-records:
+			for _, entries := range rec.RefEntries {
+				for _, e := range entries.Entries {
+					lines = append(lines, e.Line)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for records, got %v", lines)
+		}
+	}
+	return lines
+}
+
+func TestWAL_Watch(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	requireLog(t, w, newTestRecord(0, "before watching"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, err := w.(Extended).Watch(ctx)
+	require.NoError(t, err)
+
+	var expected []string
+	for i := 0; i < 9; i++ {
+		expected = append(expected, fmt.Sprintf("line %d", i))
+	}
+	go func() {
+		for i, line := range expected {
+			requireLog(t, w, newTestRecord(uint64(i), line))
+			if i%3 == 2 {
+				// rotate, deleting the previous segment once done with it
+				segment, err := w.NextSegment()
+				require.NoError(t, err)
+				if i == 5 {
+					require.NoError(t, w.DeleteSegment(segment-2))
+				}
+			}
+		}
+	}()
+
+	require.Equal(t, expected, watchLines(t, records, len(expected)))
+
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-records:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWAL_WatchSkipsDeletedSegments(t *testing.T) {
+	w, err := New(Config{Enabled: true, Dir: t.TempDir()}, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+	ww := w.(*wrapper)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, err := ww.Watch(ctx)
+	require.NoError(t, err)
+
+	// segments 1 and 2 are deleted before the watch can reach them
+	ww.mtx.Lock()
+	for i := 0; i < 3; i++ {
+		_, err := ww.wal.NextSegmentSync()
+		require.NoError(t, err)
+	}
+	ww.mtx.Unlock()
+	require.NoError(t, w.DeleteSegment(1))
+	require.NoError(t, w.DeleteSegment(2))
+	requireLog(t, w, newTestRecord(1, "line"))
+
+	require.Equal(t, []string{"line"}, watchLines(t, records, 1))
+}
+
+func TestWAL_WatchWarnsOnSegmentDeletion(t *testing.T) {
+	var logs bytes.Buffer
+	// the fake clock never ticks, so the watch is never moved to the following segments
+	w, err := newWAL(log.NewLogfmtLogger(log.NewSyncWriter(&logs)), nil, Config{Enabled: true, Dir: t.TempDir()}, "", "", withClock(newFakeClock()))
+	require.NoError(t, err)
+	defer w.Close()
+	wr := w.(*wrapper)
+	openReaders := func() float64 {
+		return testutil.ToFloat64(wr.metrics.openReaders.WithLabelValues("", ""))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	records, err := wr.Watch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1.0, openReaders())
+	_, err = w.NextSegment()
+	require.NoError(t, err)
+	require.NoError(t, w.Truncate(1))
+	segments, err := w.Segments()
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1}, segments)
+	require.NoError(t, w.DeleteSegment(0))
+	require.Contains(t, logs.String(), "deleting WAL segment an open reader is positioned in")
+
+	cancel()
+	for range records {
+	}
+	require.Eventually(t, func() bool { return openReaders() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWAL_WatchDuringReset(t *testing.T) {
+	clk := newFakeClock()
+	w, err := newWAL(log.NewNopLogger(), nil, Config{Enabled: true, Dir: t.TempDir()}, "", "", withClock(clk))
+	require.NoError(t, err)
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = w.(Extended).Watch(ctx)
+	require.NoError(t, err)
+
+	// the watch looks for new segments while the WAL is being reset, which the race detector checks
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			clk.Advance(segmentCheckPeriod)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.(Extended).Reset())
+	}
+	<-done
+}
@@ -72,6 +72,7 @@ type Watcher struct {
 	MaxSegment int
 
 	metrics *WatcherMetrics
+	pool    *wal.ResettingPool
 }
 
 // NewWatcher creates a new Watcher.
@@ -85,6 +86,7 @@ func NewWatcher(walDir, id string, metrics *WatcherMetrics, writeTo WriteTo, log
 		MaxSegment: -1,
 		logger:     logger,
 		metrics:    metrics,
+		pool:       wal.NewRecordPool(),
 	}
 }
 
@@ -146,6 +148,9 @@ func (w *Watcher) run() error {
 // reading for more WAL records with a wlog.LiveReader. Periodically, it will check if there's a new segment, and if positive
 // read the remaining from the current one and return.
 func (w *Watcher) watch(segmentNum int) error {
+	if err := checkVersionFile(w.walDir); err != nil {
+		return err
+	}
 	segment, err := wlog.OpenReadSegment(wlog.SegmentName(w.walDir, segmentNum))
 	if err != nil {
 		return err
@@ -221,7 +226,10 @@ func (w *Watcher) readSegment(r *wlog.LiveReader, segmentNum int) error {
 // decodeAndDispatch first decodes a WAL record. Upon reading either Series or Entries from the WAL record, call the
 // appropriate callbacks in the writeTo.
 func (w *Watcher) decodeAndDispatch(b []byte, segmentNum int) error {
-	rec := recordPool.GetRecord()
+	if isVersion, err := checkVersionRecord(b); isVersion {
+		return err
+	}
+	rec := w.pool.GetRecord()
 	if err := wal.DecodeRecord(b, rec); err != nil {
 		w.metrics.recordDecodeFails.WithLabelValues(w.id).Inc()
 		return err
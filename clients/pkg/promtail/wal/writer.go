@@ -1,11 +1,11 @@
 package wal
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"sync"
 	"time"
 
@@ -55,13 +55,15 @@ type Writer struct {
 	closeCleaner chan struct{}
 }
 
-// NewWriter creates a new Writer.
+// NewWriter creates a new Writer. The WAL it writes is read back by a Watcher, which decodes records as written by
+// default, so Config.RecordChecksums is rejected.
 func NewWriter(walCfg Config, logger log.Logger, reg prometheus.Registerer) (*Writer, error) {
+	if walCfg.RecordChecksums {
+		return nil, errors.New("WAL record checksums can't be enabled for a WAL read by a Watcher")
+	}
 	// Start WAL
-	wl, err := New(Config{
-		Dir:     walCfg.Dir,
-		Enabled: true,
-	}, logger, reg)
+	walCfg.Enabled = true
+	wl, err := New(walCfg, logger, reg)
 	if err != nil {
 		return nil, fmt.Errorf("error starting WAL: %w", err)
 	}
@@ -147,6 +149,9 @@ func (wrt *Writer) Stop() {
 // - It's not the last (highest numbered) segment
 // - It's last modified date is older than the max allowed age
 func (wrt *Writer) cleanSegments(maxAge time.Duration) error {
+	if IsNoop(wrt.wal) {
+		return nil
+	}
 	maxModifiedAt := time.Now().Add(-maxAge)
 	walDir := wrt.wal.Dir()
 	segments, err := listSegments(walDir)
@@ -171,6 +176,9 @@ func (wrt *Writer) cleanSegments(maxAge time.Duration) error {
 			if err := os.Remove(filepath.Join(walDir, segment.name)); err != nil {
 				level.Error(wrt.log).Log("msg", "Error old wal segment", "err", err, "segmentNum", segment.number)
 			}
+			if err := os.Remove(filepath.Join(walDir, metaName(segment.number))); err != nil && !os.IsNotExist(err) {
+				level.Warn(wrt.log).Log("msg", "Error deleting old wal segment metadata", "err", err, "segmentNum", segment.number)
+			}
 			level.Debug(wrt.log).Log("msg", "Deleted old wal segment", "segmentNum", segment.number)
 			wrt.reclaimedOldSegmentsSpaceCounter.WithLabelValues().Add(float64(segment.size))
 			// keep track of the largest segment number reclaimed
@@ -221,7 +229,7 @@ func (ew *entryWriter) WriteEntry(entry api.Entry, wl WAL, logger log.Logger) {
 	ew.reusableWALRecord.Series = ew.reusableWALRecord.Series[:0]
 
 	defer func() {
-		err := wl.Log(ew.reusableWALRecord)
+		_, err := wl.Log(ew.reusableWALRecord)
 		if err != nil {
 			level.Error(logger).Log("msg", "Failed to write entry to wal", "err", err)
 		}
@@ -252,17 +260,39 @@ type segmentRef struct {
 	lastModified time.Time
 }
 
-// listSegments list wal segments under the given directory, alongside with some file system information for each.
+// listSegments list wal segments under the given directory, alongside with some file system information for each. The
+// segments are required to be sequential.
 func listSegments(dir string) (refs []segmentRef, err error) {
-	files, err := os.ReadDir(dir)
+	refs, err = readSegmentRefs(OSFS{}, dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(refs)-1; i++ {
+		if refs[i].number+1 != refs[i+1].number {
+			return nil, fmt.Errorf("segments are not sequential")
+		}
+	}
+	return refs, nil
+}
+
+// readSegmentRefs list wal segments under the given directory sorted by segment number, alongside with some file system
+// information for each, read through fsys. Files that are not named as a segment number are skipped, and gaps between
+// segments are allowed.
+func readSegmentRefs(fsys FS, dir string) (refs []segmentRef, err error) {
+	return readNamedSegmentRefs(fsys, dir, defaultSegmentNumber)
+}
+
+// readNamedSegmentRefs is readSegmentRefs, recognizing segments and parsing their numbers with segmentNumber.
+func readNamedSegmentRefs(fsys FS, dir string, segmentNumber func(name string) (int, bool)) (refs []segmentRef, err error) {
+	files, err := fsys.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 	// the following will attempt to get segments info in a best effort manner, omitting file if error
 	for _, f := range files {
 		fn := f.Name()
-		k, err := strconv.Atoi(fn)
-		if err != nil {
+		k, ok := segmentNumber(fn)
+		if !ok {
 			continue
 		}
 		fileInfo, err := f.Info()
@@ -279,10 +309,5 @@ func listSegments(dir string) (refs []segmentRef, err error) {
 	sort.Slice(refs, func(i, j int) bool {
 		return refs[i].number < refs[j].number
 	})
-	for i := 0; i < len(refs)-1; i++ {
-		if refs[i].number+1 != refs[i+1].number {
-			return nil, fmt.Errorf("segments are not sequential")
-		}
-	}
 	return refs, nil
 }
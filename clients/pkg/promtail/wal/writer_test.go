@@ -68,6 +68,15 @@ func (n notifySegmentsCleanedFunc) SeriesReset(segmentNum int) {
 	n(segmentNum)
 }
 
+func TestWriter_RejectsRecordChecksums(t *testing.T) {
+	_, err := NewWriter(Config{
+		Dir:             t.TempDir(),
+		Enabled:         true,
+		RecordChecksums: true,
+	}, log.NewNopLogger(), prometheus.NewRegistry())
+	require.EqualError(t, err, "WAL record checksums can't be enabled for a WAL read by a Watcher")
+}
+
 func TestWriter_OldSegmentsAreCleanedUp(t *testing.T) {
 	logger := level.NewFilter(log.NewLogfmtLogger(os.Stdout), level.AllowDebug())
 	dir := t.TempDir()
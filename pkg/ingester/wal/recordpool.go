@@ -9,7 +9,19 @@ type ResettingPool struct {
 	bPool *sync.Pool // bytes
 }
 
+// defaultBufferSize is the initial capacity of the byte buffers handed out by a ResettingPool.
+const defaultBufferSize = 1 << 10 // 1kb
+
 func NewRecordPool() *ResettingPool {
+	return NewRecordPoolWithBufferSize(defaultBufferSize)
+}
+
+// NewRecordPoolWithBufferSize creates a ResettingPool whose byte buffers are allocated with an initial capacity of
+// bufferSize bytes. A non-positive bufferSize falls back to the default capacity.
+func NewRecordPoolWithBufferSize(bufferSize int) *ResettingPool {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
 	return &ResettingPool{
 		rPool: &sync.Pool{
 			New: func() interface{} {
@@ -18,8 +30,8 @@ func NewRecordPool() *ResettingPool {
 		},
 		bPool: &sync.Pool{
 			New: func() interface{} {
-				buf := new([]byte)            // Attempt to force allocation on heap.
-				*buf = make([]byte, 0, 1<<10) // 1kb
+				buf := new([]byte) // Attempt to force allocation on heap.
+				*buf = make([]byte, 0, bufferSize)
 				return buf
 			},
 		},